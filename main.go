@@ -4,14 +4,20 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/logger"
+	"github.com/docker/docker/pkg/ioutils"
 	"github.com/docker/go-plugins-helpers/sdk"
 
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/admin"
 	"github.com/rchicoli/docker-log-elasticsearch/pkg/docker"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/metrics"
 )
 
 var logLevels = map[string]log.Level{
@@ -32,6 +38,12 @@ type StopLoggingRequest struct {
 	File string `json:"file,omitempty"`
 }
 
+// ReadLogsRequest payload
+type ReadLogsRequest struct {
+	Info   logger.Info       `json:"info,omitempty"`
+	Config docker.ReadConfig `json:"config,omitempty"`
+}
+
 // CapabilitiesResponse payload
 type CapabilitiesResponse struct {
 	Cap logger.Capability `json:"capabilities,omitempty"`
@@ -54,9 +66,33 @@ func main() {
 		os.Exit(1)
 	}
 
+	readLogs := false
+	if readLogsVal := os.Getenv("READ_LOGS"); readLogsVal != "" {
+		var err error
+		if readLogs, err = strconv.ParseBool(readLogsVal); err != nil {
+			fmt.Fprintln(os.Stderr, "invalid read logs: ", readLogsVal)
+			os.Exit(1)
+		}
+	}
+
+	if metricsAddr := os.Getenv("METRICS_ADDR"); metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics.Handler())
+		go func() {
+			if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+				log.WithError(err).Fatal("error: serving metrics")
+			}
+		}()
+	}
+
 	h := sdk.NewHandler(`{"Implements": ["LoggingDriver"]}`)
 	d := docker.NewDriver()
 
+	// no container is logging yet, the caches of removed containers are deleted
+	if err := d.PruneCaches(); err != nil {
+		log.WithError(err).Error("could not prune local caches")
+	}
+
 	h.HandleFunc("/LogDriver.StartLogging", func(w http.ResponseWriter, r *http.Request) {
 		var req StartLoggingRequest
 
@@ -91,30 +127,52 @@ func main() {
 
 	h.HandleFunc("/LogDriver.Capabilities", func(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(&CapabilitiesResponse{
-			Cap: logger.Capability{ReadLogs: false},
+			Cap: logger.Capability{ReadLogs: readLogs},
 		})
 	})
 
-	// TODO: implement read logs from elasticsearch
-	// h.HandleFunc("/LogDriver.ReadLogs", func(w http.ResponseWriter, r *http.Request) {
-	// 	var req ReadLogsRequest
-	// 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-	// 		http.Error(w, err.Error(), http.StatusBadRequest)
-	// 		return
-	// 	}
-
-	// 	stream, err := d.ReadLogs(req.Info, req.Config)
-	// 	if err != nil {
-	// 		http.Error(w, err.Error(), http.StatusInternalServerError)
-	// 		return
-	// 	}
-	// 	defer stream.Close()
-
-	// 	w.Header().Set("Content-Type", "application/x-json-stream")
-	// 	wf := ioutils.NewWriteFlusher(w)
-	// 	io.Copy(wf, stream)
-
-	// })
+	h.HandleFunc("/LogDriver.ReadLogs", func(w http.ResponseWriter, r *http.Request) {
+		var req ReadLogsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if req.Info.ContainerID == "" {
+			http.Error(w, "error: could not find containerID in request payload", http.StatusBadRequest)
+			return
+		}
+
+		stream, err := d.ReadLogs(req.Info, req.Config)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer stream.Close()
+
+		// stop following the logs, once the client disconnects
+		go func() {
+			<-r.Context().Done()
+			stream.Close()
+		}()
+
+		w.Header().Set("Content-Type", "application/x-json-stream")
+		wf := ioutils.NewWriteFlusher(w)
+		io.Copy(wf, stream)
+	})
+
+	if adminSocket := os.Getenv("ADMIN_SOCKET"); adminSocket != "" {
+		os.Remove(adminSocket)
+		l, err := net.Listen("unix", adminSocket)
+		if err != nil {
+			log.WithError(err).Fatal("error: listening on admin socket")
+		}
+		go func() {
+			if err := http.Serve(l, admin.NewHandler(d)); err != nil {
+				log.WithError(err).Fatal("error: serving admin api")
+			}
+		}()
+	}
 
 	if err := h.ServeUnix(d.Name(), 0); err != nil {
 		log.WithError(err).Fatal("error: serving unix")
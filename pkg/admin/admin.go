@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/docker"
+)
+
+// Driver is the part of the logging driver, which is managed by the admin API
+type Driver interface {
+	Containers() []docker.ContainerStatus
+	Flush(containerID string) error
+	Update(containerID string, opts map[string]string) error
+}
+
+type response struct {
+	Err string `json:"err,omitempty"`
+}
+
+// NewHandler returns the admin API:
+//
+//	GET  /containers                 lists all containers and their config
+//	GET  /containers/{id}            shows a container and its config
+//	POST /containers/{id}/flush      flushes the queued messages
+//	POST /containers/{id}/config     changes the given log-opts
+func NewHandler(d Driver) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/containers", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respond(w, http.StatusMethodNotAllowed, fmt.Errorf("error: method not allowed: %s", r.Method))
+			return
+		}
+		json.NewEncoder(w).Encode(d.Containers())
+	})
+
+	mux.HandleFunc("/containers/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/containers/"), "/")
+		containerID := parts[0]
+
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			for _, status := range d.Containers() {
+				if status.ContainerID == containerID {
+					json.NewEncoder(w).Encode(status)
+					return
+				}
+			}
+			respond(w, http.StatusNotFound, docker.ErrContainerNotFound)
+
+		case len(parts) == 2 && parts[1] == "flush" && r.Method == http.MethodPost:
+			err := d.Flush(containerID)
+			respond(w, statusCode(err, http.StatusInternalServerError), err)
+
+		case len(parts) == 2 && parts[1] == "config" && r.Method == http.MethodPost:
+			var opts map[string]string
+			if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+				respond(w, http.StatusBadRequest, fmt.Errorf("error: could not decode payload: %v", err))
+				return
+			}
+			err := d.Update(containerID, opts)
+			respond(w, statusCode(err, http.StatusBadRequest), err)
+
+		default:
+			respond(w, http.StatusNotFound, fmt.Errorf("error: not found: %s %s", r.Method, r.URL.Path))
+		}
+	})
+
+	return mux
+}
+
+func statusCode(err error, failure int) int {
+	switch err {
+	case nil:
+		return http.StatusOK
+	case docker.ErrContainerNotFound:
+		return http.StatusNotFound
+	default:
+		return failure
+	}
+}
+
+func respond(w http.ResponseWriter, status int, err error) {
+	var res response
+	if err != nil {
+		res.Err = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&res)
+}
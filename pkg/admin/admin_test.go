@@ -0,0 +1,71 @@
+package admin
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/docker"
+)
+
+type fakeDriver struct {
+	opts map[string]string
+}
+
+func (f *fakeDriver) Containers() []docker.ContainerStatus {
+	return []docker.ContainerStatus{{ContainerID: "abc", Config: map[string]string{"elasticsearch-password": "********"}}}
+}
+
+func (f *fakeDriver) Flush(containerID string) error {
+	if containerID != "abc" {
+		return docker.ErrContainerNotFound
+	}
+	return nil
+}
+
+func (f *fakeDriver) Update(containerID string, opts map[string]string) error {
+	if containerID != "abc" {
+		return docker.ErrContainerNotFound
+	}
+	if _, exists := opts["elasticsearch-url"]; exists {
+		return errors.New("error: log-opt cannot be changed at runtime: elasticsearch-url")
+	}
+	f.opts = opts
+	return nil
+}
+
+func Test_NewHandler(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+		want   string
+	}{
+		{name: "list", method: "GET", path: "/containers", status: http.StatusOK, want: `"containerID":"abc"`},
+		{name: "show", method: "GET", path: "/containers/abc", status: http.StatusOK, want: `"elasticsearch-password":"********"`},
+		{name: "show unknown", method: "GET", path: "/containers/xyz", status: http.StatusNotFound, want: "container not found"},
+		{name: "flush", method: "POST", path: "/containers/abc/flush", status: http.StatusOK, want: "{}"},
+		{name: "flush unknown", method: "POST", path: "/containers/xyz/flush", status: http.StatusNotFound, want: "container not found"},
+		{name: "config", method: "POST", path: "/containers/abc/config", body: `{"elasticsearch-index":"app"}`, status: http.StatusOK, want: "{}"},
+		{name: "config rejected", method: "POST", path: "/containers/abc/config", body: `{"elasticsearch-url":"http://es:9200"}`, status: http.StatusBadRequest, want: "cannot be changed"},
+		{name: "config invalid", method: "POST", path: "/containers/abc/config", body: `[]`, status: http.StatusBadRequest, want: "could not decode payload"},
+		{name: "unknown path", method: "GET", path: "/containers/abc/unknown", status: http.StatusNotFound, want: "not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			NewHandler(&fakeDriver{}).ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+
+			if w.Code != tt.status {
+				t.Errorf("status = %v, want %v", w.Code, tt.status)
+			}
+			if !strings.Contains(w.Body.String(), tt.want) {
+				t.Errorf("body = %s, want %s", w.Body.String(), tt.want)
+			}
+		})
+	}
+}
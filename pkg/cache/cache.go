@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+// binaryEncodeLen is the size of the length prefix of each log entry
+const binaryEncodeLen = 4
+
+// File is an on-disk ring buffer of log entries. It is split into two
+// segments, once the current segment reaches half of the maximum size,
+// it replaces the previous one, so that the oldest entries are dropped.
+type File struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	size    int64
+	file    *os.File
+	enc     logdriver.LogEntryEncoder
+}
+
+// New opens or creates the ring buffer at the given path
+func New(path string, maxSize int64) (*File, error) {
+	f := &File{
+		path:    path,
+		maxSize: maxSize,
+	}
+
+	// drop a partially written entry, e.g. after a crash
+	size, err := validSize(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.open(size); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Write appends a log entry to the ring buffer
+func (f *File) Write(entry *logdriver.LogEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return os.ErrClosed
+	}
+
+	if err := f.enc.Encode(entry); err != nil {
+		return err
+	}
+
+	f.size += int64(entry.Size() + binaryEncodeLen)
+	if f.size >= f.maxSize/2 {
+		return f.rotate()
+	}
+
+	return nil
+}
+
+// Close closes the current segment, the entries are kept on disk
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// rotate replaces the previous segment by the current one
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	return f.open(0)
+}
+
+func (f *File) open(size int64) error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = size
+	f.enc = logdriver.NewLogEntryEncoder(file)
+
+	return nil
+}
+
+// Read passes all log entries of the ring buffer to fn, the oldest first
+func Read(path string, fn func(*logdriver.LogEntry) error) error {
+	for _, segment := range []string{path + ".1", path} {
+		if _, err := readSegment(segment, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes both segments of the ring buffer
+func Remove(path string) error {
+	for _, segment := range []string{path + ".1", path} {
+		if err := os.Remove(segment); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSegment passes the log entries of a segment to fn and returns the
+// size of all complete entries. A segment, which does not exist, is empty.
+func readSegment(path string, fn func(*logdriver.LogEntry) error) (int64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var size int64
+	var entry logdriver.LogEntry
+
+	dec := logdriver.NewLogEntryDecoder(file)
+	for {
+		entry.Reset()
+		if err := dec.Decode(&entry); err != nil {
+			// the last entry may still be written or has been truncated
+			return size, nil
+		}
+		size += int64(entry.Size() + binaryEncodeLen)
+
+		if err := fn(&entry); err != nil {
+			return size, err
+		}
+	}
+}
+
+func validSize(path string) (int64, error) {
+	return readSegment(path, func(*logdriver.LogEntry) error { return nil })
+}
@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+func readLines(t *testing.T, path string) []string {
+	var lines []string
+	err := Read(path, func(entry *logdriver.LogEntry) error {
+		lines = append(lines, string(entry.Line))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	return lines
+}
+
+func Test_File(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "container.cache")
+
+	// each entry takes 20 bytes, a segment is rotated after 4 entries
+	f, err := New(path, 128)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		entry := &logdriver.LogEntry{Source: "stdout", Line: []byte(fmt.Sprintf("line %d", i))}
+		if err := f.Write(entry); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	lines := readLines(t, path)
+	want := []string{"line 4", "line 5", "line 6", "line 7", "line 8", "line 9"}
+	if fmt.Sprint(lines) != fmt.Sprint(want) {
+		t.Errorf("Read() = %v, want %v", lines, want)
+	}
+
+	// a partially written entry is dropped when reopening the cache
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte{0, 0, 0, 42, 1})
+	file.Close()
+
+	f, err = New(path, 128)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := f.Write(&logdriver.LogEntry{Source: "stdout", Line: []byte("line x")}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	f.Close()
+
+	lines = readLines(t, path)
+	want = append(want, "line x")
+	if fmt.Sprint(lines) != fmt.Sprint(want) {
+		t.Errorf("Read() = %v, want %v", lines, want)
+	}
+}
+
+func Test_Remove(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "container.cache")
+	f, err := New(path, 64)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := f.Write(&logdriver.LogEntry{Source: "stdout", Line: []byte("line")}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if err := Remove(path); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("ReadDir() = %v files, want 0", len(files))
+	}
+	// removing a cache, which does not exist, succeeds
+	if err := Remove(path); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+}
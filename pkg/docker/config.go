@@ -1,43 +1,126 @@
 package docker
 
 import (
+	"crypto/tls"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/url"
+	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/docker/docker/daemon/logger"
+
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/split"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/spool"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/tlsconfig"
 )
 
 // Configuration is a type to all log-opt provided
 type Configuration struct {
-	index    string
-	tzpe     string
-	url      string
-	timeout  time.Duration
-	fields   string
-	version  string
-	username string
-	password string
-	sniff    bool
-	insecure bool
+	index      string
+	route      string
+	pipeline   string
+	dataStream string
+	idStrategy string
+	tzpe       string
+	url        string
+	timeout    time.Duration
+	fields     string
+	version    string
+	username   string
+	password   string
+	sniff      bool
+	insecure   bool
+
+	// credentials are read from these files, unless they are given directly
+	usernameFile string
+	passwordFile string
+
+	// documents rejected by elasticsearch are wrapped into this index
+	deadLetterIndex string
+
+	TLS
+
+	IndexTemplate
+
+	stripANSI bool
+
+	localCacheMaxSize int64
+
+	Spool
+
+	Partial
+
+	Multiline
 
 	Bulk
 
 	Grok
 }
 
+// TLS configures the certificates of the elasticsearch client
+type TLS struct {
+	tlsCA         string
+	tlsCert       string
+	tlsKey        string
+	tlsServerName string
+}
+
+// IndexTemplate is installed once per cluster, optionally with an ILM policy
+type IndexTemplate struct {
+	template         bool
+	templateName     string
+	templateShards   int
+	templateReplicas int
+	ilmPolicy        string
+	ilmRolloverAge   string
+	ilmDeleteAge     string
+}
+
+// ilmAge matches the time units of elasticsearch, e.g. 30d or 12h
+var ilmAge = regexp.MustCompile(`^[0-9]+(d|h|m|s|ms|micros|nanos)$`)
+
 // Bulk configures the Bulk Processor Service
 type Bulk struct {
 	workers       int
 	actions       int
 	size          int
 	flushInterval time.Duration
+	// oversize is the policy of documents, which are too large for a bulk request
+	oversize string
+	// retries is the maximum number of attempts to resend rejected documents
+	retries int
 	// stats         bool
 }
 
+// Spool keeps failed bulk requests on disk
+type Spool struct {
+	spoolDir     string
+	spoolMaxSize int64
+	spoolDiscard string
+}
+
+// Partial reassembles partial log messages
+type Partial struct {
+	partialReassemble bool
+	partialMaxSize    int
+	partialTimeout    time.Duration
+}
+
+// Multiline merges continuation lines
+type Multiline struct {
+	multilinePattern  string
+	multilineNegate   bool
+	multilineMatch    string
+	multilineMaxLines int
+	multilineTimeout  time.Duration
+}
+
 // Grok filter
 type Grok struct {
 	grokPattern         string
@@ -57,14 +140,43 @@ func newConfiguration() Configuration {
 		sniff:    true,
 		insecure: false,
 
+		idStrategy: "uuid",
+
+		usernameFile: os.Getenv("ELASTICSEARCH_USERNAME_FILE"),
+		passwordFile: os.Getenv("ELASTICSEARCH_PASSWORD_FILE"),
+
 		Bulk: Bulk{
 			workers:       1,
 			actions:       100,
 			size:          5 << 20, // 5 MB = 0101 0000 0000 0000 0000 0000 = 5242880
 			flushInterval: 5 * time.Second,
+			oversize:      split.Drop,
+			retries:       3,
 			// stats:         false,
 		},
 
+		IndexTemplate: IndexTemplate{
+			templateName:     "docker-log-elasticsearch",
+			templateShards:   1,
+			templateReplicas: 1,
+		},
+
+		Spool: Spool{
+			spoolMaxSize: 100 << 20, // 100 MB
+			spoolDiscard: spool.DiscardOldest,
+		},
+
+		Partial: Partial{
+			partialMaxSize: 1 << 20, // 1 MB
+			partialTimeout: 5 * time.Second,
+		},
+
+		Multiline: Multiline{
+			multilineMatch:    multilineAfter,
+			multilineMaxLines: 500,
+			multilineTimeout:  5 * time.Second,
+		},
+
 		Grok: Grok{
 			grokPatternSplitter: " and ",
 			grokNamedCapture:    true,
@@ -104,13 +216,58 @@ func (c *Configuration) validateLogOpt(cfg map[string]string) error {
 			}
 			c.url = v
 		case "elasticsearch-index":
+			if _, err := template.New("index").Parse(v); err != nil {
+				return fmt.Errorf("error: parsing elasticsearch-index: %q", err)
+			}
 			c.index = v
+		case "elasticsearch-route":
+			rules, err := parseRoutes(v)
+			if err != nil {
+				return err
+			}
+			for _, rule := range rules {
+				if _, err := template.New("index").Parse(rule.index); err != nil {
+					return fmt.Errorf("error: parsing elasticsearch-route: %q", err)
+				}
+			}
+			c.route = v
+		case "elasticsearch-pipeline":
+			if _, err := template.New("pipeline").Parse(v); err != nil {
+				return fmt.Errorf("error: parsing elasticsearch-pipeline: %q", err)
+			}
+			c.pipeline = v
+		case "elasticsearch-data-stream":
+			// data streams are not rotated by date sequences
+			if strings.Contains(v, "%") {
+				return fmt.Errorf("error: elasticsearch-data-stream does not support date sequences: %s", v)
+			}
+			if _, err := template.New("dataStream").Parse(v); err != nil {
+				return fmt.Errorf("error: parsing elasticsearch-data-stream: %q", err)
+			}
+			c.dataStream = v
+		case "elasticsearch-id-strategy":
+			switch v {
+			case "uuid", "hash", "none":
+				c.idStrategy = v
+			default:
+				return fmt.Errorf("error: elasticsearch-id-strategy not supported: %s", v)
+			}
+		case "elasticsearch-dead-letter-index":
+			// the template of the dead-letter index matches this name only
+			if strings.ContainsAny(v, "%{*") || v != strings.ToLower(v) {
+				return fmt.Errorf("error: elasticsearch-dead-letter-index must be a lowercase index name: %s", v)
+			}
+			c.deadLetterIndex = v
 		case "elasticsearch-type":
 			c.tzpe = v
 		case "elasticsearch-username":
 			c.username = v
 		case "elasticsearch-password":
 			c.password = v
+		case "elasticsearch-username-file":
+			c.usernameFile = v
+		case "elasticsearch-password-file":
+			c.passwordFile = v
 		// case "max-retry":
 		case "elasticsearch-fields":
 			for _, v := range strings.Split(v, ",") {
@@ -145,9 +302,17 @@ func (c *Configuration) validateLogOpt(cfg map[string]string) error {
 				return fmt.Errorf("error: parsing elasticsearch-insecure: %q", err)
 			}
 			c.insecure = s
+		case "elasticsearch-tls-ca":
+			c.tlsCA = v
+		case "elasticsearch-tls-cert":
+			c.tlsCert = v
+		case "elasticsearch-tls-key":
+			c.tlsKey = v
+		case "elasticsearch-tls-server-name":
+			c.tlsServerName = v
 		case "elasticsearch-version":
 			switch v {
-			case "1", "2", "5", "6":
+			case "1", "2", "5", "6", "7", "8", "opensearch", "auto":
 				c.version = v
 			default:
 				return fmt.Errorf("error: elasticsearch-version not supported: %s", v)
@@ -183,6 +348,22 @@ func (c *Configuration) validateLogOpt(cfg map[string]string) error {
 				return fmt.Errorf("error: parsing elasticsearch-bulk-flush-interval: %q", err)
 			}
 			c.Bulk.flushInterval = flushInterval
+		case "elasticsearch-bulk-retries":
+			retries, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing elasticsearch-bulk-retries: %q", err)
+			}
+			if retries < 0 {
+				return fmt.Errorf("error: elasticsearch-bulk-retries must not be negative: %s", v)
+			}
+			c.Bulk.retries = retries
+		case "elasticsearch-bulk-oversize":
+			switch v {
+			case split.Drop, split.Truncate:
+				c.Bulk.oversize = v
+			default:
+				return fmt.Errorf("error: elasticsearch-bulk-oversize not supported: %s", v)
+			}
 		// case "elasticsearch-bulk-stats":
 		// 	stats, err := strconv.ParseBool(v)
 		// 	if err != nil {
@@ -190,6 +371,140 @@ func (c *Configuration) validateLogOpt(cfg map[string]string) error {
 		// 	}
 		// 	c.Bulk.stats = stats
 
+		case "strip-ansi":
+			s, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing strip-ansi: %q", err)
+			}
+			c.stripANSI = s
+
+		case "local-cache-max-size":
+			size, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("error: parsing local-cache-max-size: %q", err)
+			}
+			if size < 0 {
+				return fmt.Errorf("error: local-cache-max-size must not be negative: %s", v)
+			}
+			c.localCacheMaxSize = size
+
+		case "elasticsearch-template":
+			s, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing elasticsearch-template: %q", err)
+			}
+			c.template = s
+		case "elasticsearch-template-name":
+			if v == "" {
+				return fmt.Errorf("error: elasticsearch-template-name must not be empty")
+			}
+			c.templateName = v
+		case "elasticsearch-template-shards":
+			shards, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing elasticsearch-template-shards: %q", err)
+			}
+			if shards < 1 {
+				return fmt.Errorf("error: elasticsearch-template-shards must be positive: %s", v)
+			}
+			c.templateShards = shards
+		case "elasticsearch-template-replicas":
+			replicas, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing elasticsearch-template-replicas: %q", err)
+			}
+			if replicas < 0 {
+				return fmt.Errorf("error: elasticsearch-template-replicas must not be negative: %s", v)
+			}
+			c.templateReplicas = replicas
+		case "elasticsearch-ilm-policy":
+			c.ilmPolicy = v
+		case "elasticsearch-ilm-rollover-age":
+			if !ilmAge.MatchString(v) {
+				return fmt.Errorf("error: parsing elasticsearch-ilm-rollover-age: %s", v)
+			}
+			c.ilmRolloverAge = v
+		case "elasticsearch-ilm-delete-age":
+			if !ilmAge.MatchString(v) {
+				return fmt.Errorf("error: parsing elasticsearch-ilm-delete-age: %s", v)
+			}
+			c.ilmDeleteAge = v
+
+		case "spool-dir":
+			c.spoolDir = v
+		case "spool-max-size":
+			size, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return fmt.Errorf("error: parsing spool-max-size: %q", err)
+			}
+			if size < 0 {
+				return fmt.Errorf("error: spool-max-size must not be negative: %s", v)
+			}
+			c.spoolMaxSize = size
+		case "spool-discard":
+			switch v {
+			case spool.DiscardOldest, spool.DiscardNewest:
+				c.spoolDiscard = v
+			default:
+				return fmt.Errorf("error: spool-discard not supported: %s", v)
+			}
+
+		case "partial-reassemble":
+			s, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing partial-reassemble: %q", err)
+			}
+			c.partialReassemble = s
+		case "partial-max-size":
+			size, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing partial-max-size: %q", err)
+			}
+			c.partialMaxSize = size
+		case "partial-timeout":
+			timeout, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing partial-timeout: %q", err)
+			}
+			if timeout <= 0 {
+				return fmt.Errorf("error: partial-timeout must be positive: %s", v)
+			}
+			c.partialTimeout = timeout
+
+		case "multiline-pattern":
+			if _, err := regexp.Compile(v); err != nil {
+				return fmt.Errorf("error: parsing multiline-pattern: %q", err)
+			}
+			c.multilinePattern = v
+		case "multiline-negate":
+			s, err := strconv.ParseBool(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing multiline-negate: %q", err)
+			}
+			c.multilineNegate = s
+		case "multiline-match":
+			switch v {
+			case multilineAfter, multilineBefore:
+				c.multilineMatch = v
+			default:
+				return fmt.Errorf("error: multiline-match not supported: %s", v)
+			}
+		case "multiline-max-lines":
+			maxLines, err := strconv.Atoi(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing multiline-max-lines: %q", err)
+			}
+			c.multilineMaxLines = maxLines
+		case "multiline-timeout":
+			timeout, err := time.ParseDuration(v)
+			if err != nil {
+				return fmt.Errorf("error: parsing multiline-timeout: %q", err)
+			}
+			if timeout <= 0 {
+				return fmt.Errorf("error: multiline-timeout must be positive: %s", v)
+			}
+			c.multilineTimeout = timeout
+
 		case "grok-pattern":
 			c.grokPattern = v
 		case "grok-pattern-from":
@@ -210,15 +525,146 @@ func (c *Configuration) validateLogOpt(cfg map[string]string) error {
 		}
 	}
 
+	for _, key := range []string{"elasticsearch-username", "elasticsearch-password"} {
+		if _, exists := cfg[key]; exists && cfg[key+"-file"] != "" {
+			return fmt.Errorf("error: %s and %s-file cannot be used together", key, key)
+		}
+	}
+
+	// mapping types have been removed in elasticsearch 7
+	switch c.version {
+	case "7", "8", "opensearch":
+		if _, exists := cfg["elasticsearch-type"]; exists {
+			return fmt.Errorf("error: elasticsearch-type is not supported by elasticsearch-version: %s", c.version)
+		}
+		c.tzpe = ""
+	case "auto":
+		// the type is only used, if the cluster requires it
+	default:
+		if c.tzpe == "" {
+			return fmt.Errorf("error: elasticsearch-type is required by elasticsearch-version: %s", c.version)
+		}
+	}
+
+	if err := c.validateVersion(c.version); err != nil {
+		return err
+	}
+
+	// data streams cannot be combined with indices
+	if c.dataStream != "" {
+		for _, key := range []string{"elasticsearch-index", "elasticsearch-route"} {
+			if _, exists := cfg[key]; exists {
+				return fmt.Errorf("error: elasticsearch-data-stream and %s cannot be used together", key)
+			}
+		}
+	}
+
+	if c.ilmPolicy != "" {
+		if !c.template {
+			return fmt.Errorf("error: elasticsearch-ilm-policy requires elasticsearch-template")
+		}
+	} else if c.ilmRolloverAge != "" || c.ilmDeleteAge != "" {
+		return fmt.Errorf("error: elasticsearch-ilm-rollover-age and elasticsearch-ilm-delete-age require elasticsearch-ilm-policy")
+	}
+	// indices are only rolled over through a data stream, the rollover
+	// action would fail for any other index
+	if c.ilmRolloverAge != "" && c.dataStream == "" {
+		return fmt.Errorf("error: elasticsearch-ilm-rollover-age requires elasticsearch-data-stream")
+	}
+
+	// the certificates are loaded, so that invalid files are reported early
+	if _, err := c.tlsConfig(); err != nil {
+		return err
+	}
+
+	if c.username == "" && c.usernameFile != "" {
+		username, err := readSecret(c.usernameFile)
+		if err != nil {
+			return fmt.Errorf("error: reading elasticsearch-username-file: %q", err)
+		}
+		c.username = username
+	}
+	if c.password == "" && c.passwordFile != "" {
+		password, err := readSecret(c.passwordFile)
+		if err != nil {
+			return fmt.Errorf("error: reading elasticsearch-password-file: %q", err)
+		}
+		c.password = password
+	}
+
+	return nil
+}
+
+// validateVersion checks the options, which are not supported by every
+// elasticsearch-version. StartLogging checks them again, once the version
+// of the cluster has been detected, hence auto passes.
+func (c *Configuration) validateVersion(version string) error {
+
+	// ingest pipelines have been introduced in elasticsearch 5
+	if c.pipeline != "" && (version == "1" || version == "2") {
+		return fmt.Errorf("error: elasticsearch-pipeline is not supported by elasticsearch-version: %s", version)
+	}
+
+	// data streams have been introduced in elasticsearch 7.9, which is
+	// checked by StartLogging
+	if c.dataStream != "" {
+		switch version {
+		case "7", "8", "opensearch", "auto":
+		default:
+			return fmt.Errorf("error: elasticsearch-data-stream is not supported by elasticsearch-version: %s", version)
+		}
+	}
+
+	if c.deadLetterIndex != "" {
+		switch version {
+		case "1", "2":
+			return fmt.Errorf("error: elasticsearch-dead-letter-index is not supported by elasticsearch-version: %s", version)
+		}
+	}
+
+	if c.template {
+		switch version {
+		case "1", "2":
+			return fmt.Errorf("error: elasticsearch-template is not supported by elasticsearch-version: %s", version)
+		case "5":
+			// templates of elasticsearch 5 match a single pattern only
+			if c.route != "" {
+				return fmt.Errorf("error: elasticsearch-template and elasticsearch-route cannot be used together by elasticsearch-version: %s", version)
+			}
+		}
+	}
+
+	if c.ilmPolicy != "" {
+		switch version {
+		case "5", "opensearch":
+			return fmt.Errorf("error: elasticsearch-ilm-policy is not supported by elasticsearch-version: %s", version)
+		}
+	}
+
 	return nil
 }
 
+// tlsConfig loads the certificates of the elasticsearch client
+func (c Configuration) tlsConfig() (*tls.Config, error) {
+	return tlsconfig.New(c.tlsCA, c.tlsCert, c.tlsKey, c.tlsServerName, c.insecure)
+}
+
+// readSecret reads a credential from a file, e.g. a docker secret,
+// a trailing newline is removed
+func readSecret(file string) (string, error) {
+	secret, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(secret), "\r\n"), nil
+}
+
 func getLogMessageFields(fields string, info logger.Info) LogMessage {
 	var l LogMessage
 	for _, v := range strings.Split(fields, ",") {
 		switch v {
 		case "config":
-			l.Config = info.Config
+			l.Config = redactConfig(info.Config)
 		case "containerID":
 			l.ContainerID = info.ID()
 		case "containerName":
@@ -247,3 +693,99 @@ func getLogMessageFields(fields string, info logger.Info) LogMessage {
 
 	return l
 }
+
+// hasField reports whether a field is part of elasticsearch-fields
+func hasField(fields, field string) bool {
+	for _, v := range strings.Split(fields, ",") {
+		if v == field {
+			return true
+		}
+	}
+	return false
+}
+
+// logOpts returns the effective log-opts, secrets are masked
+func (c Configuration) logOpts() map[string]string {
+	return map[string]string{
+		"elasticsearch-url":                 c.url,
+		"elasticsearch-index":               c.index,
+		"elasticsearch-route":               c.route,
+		"elasticsearch-pipeline":            c.pipeline,
+		"elasticsearch-data-stream":         c.dataStream,
+		"elasticsearch-id-strategy":         c.idStrategy,
+		"elasticsearch-dead-letter-index":   c.deadLetterIndex,
+		"elasticsearch-template":            strconv.FormatBool(c.template),
+		"elasticsearch-template-name":       c.templateName,
+		"elasticsearch-template-shards":     strconv.Itoa(c.templateShards),
+		"elasticsearch-template-replicas":   strconv.Itoa(c.templateReplicas),
+		"elasticsearch-ilm-policy":          c.ilmPolicy,
+		"elasticsearch-ilm-rollover-age":    c.ilmRolloverAge,
+		"elasticsearch-ilm-delete-age":      c.ilmDeleteAge,
+		"elasticsearch-type":                c.tzpe,
+		"elasticsearch-username":            c.username,
+		"elasticsearch-password":            mask(c.password),
+		"elasticsearch-username-file":       c.usernameFile,
+		"elasticsearch-password-file":       c.passwordFile,
+		"elasticsearch-fields":              c.fields,
+		"elasticsearch-sniff":               strconv.FormatBool(c.sniff),
+		"elasticsearch-insecure":            strconv.FormatBool(c.insecure),
+		"elasticsearch-tls-ca":              c.tlsCA,
+		"elasticsearch-tls-cert":            c.tlsCert,
+		"elasticsearch-tls-key":             c.tlsKey,
+		"elasticsearch-tls-server-name":     c.tlsServerName,
+		"elasticsearch-version":             c.version,
+		"elasticsearch-timeout":             c.timeout.String(),
+		"elasticsearch-bulk-workers":        strconv.Itoa(c.Bulk.workers),
+		"elasticsearch-bulk-actions":        strconv.Itoa(c.Bulk.actions),
+		"elasticsearch-bulk-size":           strconv.Itoa(c.Bulk.size),
+		"elasticsearch-bulk-flush-interval": c.Bulk.flushInterval.String(),
+		"elasticsearch-bulk-oversize":       c.Bulk.oversize,
+		"elasticsearch-bulk-retries":        strconv.Itoa(c.Bulk.retries),
+		"strip-ansi":                        strconv.FormatBool(c.stripANSI),
+		"local-cache-max-size":              strconv.FormatInt(c.localCacheMaxSize, 10),
+		"spool-dir":                         c.spoolDir,
+		"spool-max-size":                    strconv.FormatInt(c.spoolMaxSize, 10),
+		"spool-discard":                     c.spoolDiscard,
+		"partial-reassemble":                strconv.FormatBool(c.partialReassemble),
+		"partial-max-size":                  strconv.Itoa(c.partialMaxSize),
+		"partial-timeout":                   c.partialTimeout.String(),
+		"multiline-pattern":                 c.multilinePattern,
+		"multiline-negate":                  strconv.FormatBool(c.multilineNegate),
+		"multiline-match":                   c.multilineMatch,
+		"multiline-max-lines":               strconv.Itoa(c.multilineMaxLines),
+		"multiline-timeout":                 c.multilineTimeout.String(),
+		"grok-pattern":                      c.grokPattern,
+		"grok-pattern-from":                 c.grokPatternFrom,
+		"grok-pattern-splitter":             c.grokPatternSplitter,
+		"grok-match":                        c.grokMatch,
+		"grok-named-capture":                strconv.FormatBool(c.grokNamedCapture),
+	}
+}
+
+// mask hides a secret, but shows whether it has been set
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "********"
+}
+
+// credentialKeys are never indexed as part of the config field
+var credentialKeys = map[string]bool{
+	"elasticsearch-username": true,
+	"elasticsearch-password": true,
+}
+
+// redactConfig returns a copy of the log-opts without credentials
+func redactConfig(config map[string]string) map[string]string {
+	if config == nil {
+		return nil
+	}
+	redacted := make(map[string]string, len(config))
+	for key, value := range config {
+		if !credentialKeys[key] {
+			redacted[key] = value
+		}
+	}
+	return redacted
+}
@@ -1,6 +1,14 @@
 package docker
 
-import "testing"
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
 
 func Test_parseAddress(t *testing.T) {
 	type args struct {
@@ -24,3 +32,356 @@ func Test_parseAddress(t *testing.T) {
 		})
 	}
 }
+
+func Test_containerUpdate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    map[string]string
+		want    string
+		wantErr bool
+	}{
+		{name: "index", opts: map[string]string{"elasticsearch-index": "App-%Y"}, want: "app-2018"},
+		{name: "flush interval", opts: map[string]string{"elasticsearch-bulk-flush-interval": "1s"}, want: "docker-"},
+		{name: "grok", opts: map[string]string{"grok-match": "%{WORD:word}"}, want: "docker-"},
+		{name: "invalid value", opts: map[string]string{"elasticsearch-bulk-flush-interval": "1"}, wantErr: true},
+		{name: "not changeable", opts: map[string]string{"elasticsearch-url": "http://127.0.0.1:9200"}, wantErr: true},
+	}
+	doc := LogMessage{}
+	doc.TimeNano = time.Date(2018, 1, 2, 3, 4, 5, 0, time.Local).UnixNano()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &container{
+				config:     newConfiguration(),
+				indexNames: newIndexNames("docker-"),
+				logger:     log.WithField("test", tt.name),
+				pipeline:   pipeline{reloadCh: make(chan struct{}, 1)},
+			}
+			c.config.index = "docker-"
+
+			if err := c.Update(tt.opts); (err != nil) != tt.wantErr {
+				t.Errorf("Update() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && c.index(doc) != tt.want {
+				t.Errorf("index() = %v, want %v", c.index(doc), tt.want)
+			}
+		})
+	}
+}
+
+func Test_logOpts(t *testing.T) {
+	c := newConfiguration()
+	c.password = "secret"
+	if got := c.logOpts()["elasticsearch-password"]; got != "********" {
+		t.Errorf("logOpts() password = %v, want masked", got)
+	}
+}
+
+func Test_validateLogOptSecrets(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "password")
+	if err := ioutil.WriteFile(file, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cfg      map[string]string
+		password string
+		wantErr  bool
+	}{
+		{name: "password file", cfg: map[string]string{"elasticsearch-password-file": file}, password: "secret"},
+		{name: "password", cfg: map[string]string{"elasticsearch-password": "changeme"}, password: "changeme"},
+		{name: "both", cfg: map[string]string{"elasticsearch-password": "changeme", "elasticsearch-password-file": file}, wantErr: true},
+		{name: "missing file", cfg: map[string]string{"elasticsearch-password-file": filepath.Join(dir, "missing")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && c.password != tt.password {
+				t.Errorf("validateLogOpt() password = %v, want %v", c.password, tt.password)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptType(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		tzpe    string
+		wantErr bool
+	}{
+		{name: "default", cfg: map[string]string{}, tzpe: "log"},
+		{name: "version 6", cfg: map[string]string{"elasticsearch-version": "6", "elasticsearch-type": "doc"}, tzpe: "doc"},
+		{name: "version 6 without type", cfg: map[string]string{"elasticsearch-version": "6", "elasticsearch-type": ""}, wantErr: true},
+		{name: "version 7", cfg: map[string]string{"elasticsearch-version": "7"}, tzpe: ""},
+		{name: "version 7 with type", cfg: map[string]string{"elasticsearch-version": "7", "elasticsearch-type": "log"}, wantErr: true},
+		{name: "opensearch", cfg: map[string]string{"elasticsearch-version": "opensearch"}, tzpe: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && c.tzpe != tt.tzpe {
+				t.Errorf("validateLogOpt() tzpe = %v, want %v", c.tzpe, tt.tzpe)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptPipeline(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		wantErr bool
+	}{
+		{name: "pipeline", cfg: map[string]string{"elasticsearch-version": "5", "elasticsearch-pipeline": "logs"}},
+		{name: "pipeline version 2", cfg: map[string]string{"elasticsearch-version": "2", "elasticsearch-pipeline": "logs"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptBulkOversize(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		wantErr bool
+	}{
+		{name: "bulk oversize", cfg: map[string]string{"elasticsearch-bulk-oversize": "truncate"}},
+		{name: "invalid bulk oversize", cfg: map[string]string{"elasticsearch-bulk-oversize": "split"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptBulkRetries(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		wantErr bool
+	}{
+		{name: "bulk retries", cfg: map[string]string{"elasticsearch-bulk-retries": "0"}},
+		{name: "negative bulk retries", cfg: map[string]string{"elasticsearch-bulk-retries": "-1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptIDStrategy(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		wantErr bool
+	}{
+		{name: "id strategy", cfg: map[string]string{"elasticsearch-id-strategy": "hash"}},
+		{name: "invalid id strategy", cfg: map[string]string{"elasticsearch-id-strategy": "random"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptDeadLetterIndex(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		wantErr bool
+	}{
+		{name: "dead-letter index", cfg: map[string]string{"elasticsearch-dead-letter-index": "docker-dead-letter"}},
+		{name: "dead-letter index with date", cfg: map[string]string{"elasticsearch-dead-letter-index": "docker-dead-letter-%Y"}, wantErr: true},
+		{name: "dead-letter index version 2", cfg: map[string]string{"elasticsearch-version": "2", "elasticsearch-dead-letter-index": "docker-dead-letter"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptDataStream(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		wantErr bool
+	}{
+		{name: "data stream", cfg: map[string]string{"elasticsearch-version": "8", "elasticsearch-data-stream": "logs-docker-default"}},
+		{name: "data stream version 6", cfg: map[string]string{"elasticsearch-version": "6", "elasticsearch-data-stream": "logs-docker-default"}, wantErr: true},
+		{name: "data stream with index", cfg: map[string]string{"elasticsearch-version": "8", "elasticsearch-data-stream": "logs-docker-default", "elasticsearch-index": "docker"}, wantErr: true},
+		{name: "data stream with date", cfg: map[string]string{"elasticsearch-version": "8", "elasticsearch-data-stream": "logs-docker-%Y"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		wantErr bool
+	}{
+		{name: "template", cfg: map[string]string{"elasticsearch-version": "7", "elasticsearch-template": "true"}},
+		{name: "template version 2", cfg: map[string]string{"elasticsearch-version": "2", "elasticsearch-template": "true"}, wantErr: true},
+		{name: "template version 5", cfg: map[string]string{"elasticsearch-version": "5", "elasticsearch-template": "true"}},
+		{name: "template version 5 with route", cfg: map[string]string{"elasticsearch-version": "5", "elasticsearch-template": "true", "elasticsearch-route": "source==stderr:docker-errors"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptILM(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		wantErr bool
+	}{
+		{name: "ilm policy", cfg: map[string]string{"elasticsearch-version": "7", "elasticsearch-template": "true", "elasticsearch-ilm-policy": "logs", "elasticsearch-ilm-delete-age": "30d"}},
+		{name: "ilm policy without template", cfg: map[string]string{"elasticsearch-version": "7", "elasticsearch-ilm-policy": "logs"}, wantErr: true},
+		{name: "ilm policy version 5", cfg: map[string]string{"elasticsearch-version": "5", "elasticsearch-template": "true", "elasticsearch-ilm-policy": "logs"}, wantErr: true},
+		{name: "ilm rollover age with data stream", cfg: map[string]string{"elasticsearch-version": "7", "elasticsearch-template": "true", "elasticsearch-data-stream": "logs-docker", "elasticsearch-ilm-policy": "logs", "elasticsearch-ilm-rollover-age": "1d"}},
+		{name: "ilm rollover age without data stream", cfg: map[string]string{"elasticsearch-version": "7", "elasticsearch-template": "true", "elasticsearch-ilm-policy": "logs", "elasticsearch-ilm-rollover-age": "1d"}, wantErr: true},
+		{name: "ilm delete age without policy", cfg: map[string]string{"elasticsearch-version": "7", "elasticsearch-template": "true", "elasticsearch-ilm-delete-age": "30d"}, wantErr: true},
+		{name: "ilm invalid age", cfg: map[string]string{"elasticsearch-version": "7", "elasticsearch-template": "true", "elasticsearch-ilm-policy": "logs", "elasticsearch-ilm-delete-age": "30 days"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		version string
+		wantErr bool
+	}{
+		{name: "pipeline", cfg: map[string]string{"elasticsearch-pipeline": "logs"}, version: "5"},
+		{name: "pipeline version 2", cfg: map[string]string{"elasticsearch-pipeline": "logs"}, version: "2", wantErr: true},
+		{name: "dead-letter index", cfg: map[string]string{"elasticsearch-dead-letter-index": "docker-dead-letter"}, version: "6"},
+		{name: "dead-letter index version 1", cfg: map[string]string{"elasticsearch-dead-letter-index": "docker-dead-letter"}, version: "1", wantErr: true},
+		{name: "data stream version 6", cfg: map[string]string{"elasticsearch-data-stream": "logs-docker-default"}, version: "6", wantErr: true},
+		{name: "ilm policy opensearch", cfg: map[string]string{"elasticsearch-template": "true", "elasticsearch-ilm-policy": "logs"}, version: "opensearch", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the options pass, as long as the version is detected at startup
+			tt.cfg["elasticsearch-version"] = "auto"
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); err != nil {
+				t.Fatalf("validateLogOpt() error = %v", err)
+			}
+			if err := c.validateVersion(tt.version); (err != nil) != tt.wantErr {
+				t.Errorf("validateVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptLocalCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		wantErr bool
+	}{
+		{name: "local cache max size", cfg: map[string]string{"local-cache-max-size": "10485760"}},
+		{name: "disabled local cache", cfg: map[string]string{"local-cache-max-size": "0"}},
+		{name: "negative local cache max size", cfg: map[string]string{"local-cache-max-size": "-1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateLogOptSpool(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     map[string]string
+		wantErr bool
+	}{
+		{name: "spool max size", cfg: map[string]string{"spool-max-size": "1048576"}},
+		{name: "negative spool max size", cfg: map[string]string{"spool-max-size": "-1"}, wantErr: true},
+		{name: "spool discard", cfg: map[string]string{"spool-discard": "oldest"}},
+		{name: "invalid spool discard", cfg: map[string]string{"spool-discard": "all"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConfiguration()
+			if err := c.validateLogOpt(tt.cfg); (err != nil) != tt.wantErr {
+				t.Errorf("validateLogOpt() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_redactConfig(t *testing.T) {
+	config := map[string]string{
+		"elasticsearch-url":      "http://127.0.0.1:9200",
+		"elasticsearch-username": "elastic",
+		"elasticsearch-password": "changeme",
+	}
+	got := redactConfig(config)
+	if _, exists := got["elasticsearch-password"]; exists || len(got) != 1 {
+		t.Errorf("redactConfig() = %v, want only elasticsearch-url", got)
+	}
+	if len(config) != 3 {
+		t.Errorf("redactConfig() modified the log-opts: %v", config)
+	}
+}
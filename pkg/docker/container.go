@@ -4,10 +4,12 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,34 +17,57 @@ import (
 	"github.com/docker/docker/api/types/plugins/logdriver"
 	"github.com/docker/docker/daemon/logger"
 	protoio "github.com/gogo/protobuf/io"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/cache"
 	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/resend"
 	"github.com/rchicoli/docker-log-elasticsearch/pkg/extension/grok"
-	"github.com/robfig/cron"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/metrics"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/regex"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/spool"
 	"github.com/tonistiigi/fifo"
 	"golang.org/x/sync/errgroup"
 )
 
 type container struct {
 	// bulkService map[int]*BulkWorker
-	cron      *cron.Cron
-	esClient  elasticsearch.Client
-	indexName string
-	logger    *log.Entry
-	pipeline  pipeline
-	stream    io.ReadCloser
+	cache       *cache.File
+	containerID string
+	// done is closed, once the container stopped logging
+	done     chan struct{}
+	esClient elasticsearch.Client
+	file     string
+	info     logger.Info
+	logger   *log.Entry
+	metrics  *metrics.Container
+	pipeline pipeline
+	spool    *spool.Spool
+	stream   io.ReadCloser
+
+	// mu guards the settings, which can be changed at runtime
+	mu         sync.Mutex
+	config     Configuration
+	groker     grok.Grok
+	indexNames *indexNames
+	routes     []route
+	// pipelineNames is nil, if no ingest pipeline is used
+	pipelineNames *indexNames
 }
 
 type pipeline struct {
 	// commitCh chan struct{}
 	group    *errgroup.Group
-	inputCh  chan logdriver.LogEntry
+	inputCh  chan logEntry
 	outputCh chan LogMessage
+	// flushCh requests an immediate flush of the bulk processor
+	flushCh chan chan error
+	// reloadCh notifies the log pipeline about a changed flush interval
+	reloadCh chan struct{}
 }
 
 // Processor interface
 // type Processor interface {
 // 	Read(ctx context.Context) error
-// 	Parse(ctx context.Context, info logger.Info, fields, grokMatch, grokPattern, grokPatternFrom, grokPatternSplitter string, grokNamedCapture bool) error
+// 	Parse(ctx context.Context, info logger.Info, fields, grokMatch, grokPattern, grokPatternFrom, grokPatternSplitter string, grokNamedCapture, stripANSI bool) error
 // 	Log(ctx context.Context, workers int, indexName, tzpe string, actions, bulkSize int, flushInterval, timeout time.Duration) error
 // }
 
@@ -57,31 +82,56 @@ func newContainer(ctx context.Context, file, containerID string) (*container, er
 
 	return &container{
 		// bulkService: make(map[int]*BulkWorker),
-		stream: f,
-		logger: log.WithField("containerID", containerID),
+		containerID: containerID,
+		done:        make(chan struct{}),
+		file:        file,
+		stream:      f,
+		logger:      log.WithField("containerID", containerID),
 		pipeline: pipeline{
 			// commitCh: make(chan struct{}),
-			inputCh:  make(chan logdriver.LogEntry),
+			inputCh:  make(chan logEntry),
 			outputCh: make(chan LogMessage),
+			flushCh:  make(chan chan error),
+			reloadCh: make(chan struct{}, 1),
 		},
 	}, nil
 }
 
+// abort releases the resources of a container, which could not start logging
+func (c *container) abort() {
+	if c.stream != nil {
+		c.stream.Close()
+	}
+	if c.esClient != nil {
+		c.esClient.Stop()
+	}
+	if c.cache != nil {
+		if err := c.cache.Close(); err != nil {
+			c.logger.WithError(err).Error("could not close local cache")
+		}
+	}
+	metrics.Remove(c.containerID)
+	close(c.done)
+}
+
 // Read reads messages from proto buffer
 func (c *container) Read(ctx context.Context) error {
 
 	c.logger.Debug("starting pipeline: Read")
 
+	// the following stages may replace the input channel
+	inputCh := c.pipeline.inputCh
+
 	c.pipeline.group.Go(func() error {
 
 		dec := protoio.NewUint32DelimitedReader(c.stream, binary.BigEndian, 1e6)
 		defer func() {
 			c.logger.Info("closing docker stream")
 			dec.Close()
-			close(c.pipeline.inputCh)
+			close(inputCh)
 		}()
 
-		var buf logdriver.LogEntry
+		var buf logEntry
 		var err error
 
 		for {
@@ -109,14 +159,24 @@ func (c *container) Read(ctx context.Context) error {
 				dec = protoio.NewUint32DelimitedReader(c.stream, binary.BigEndian, 1e6)
 			}
 
+			c.metrics.LineRead()
+
 			// in case docker run command throws lots empty line messages
-			if len(bytes.TrimSpace(buf.Line)) == 0 {
+			// fragments of partial messages are kept for reassembling them
+			if !buf.Partial && len(bytes.TrimSpace(buf.Line)) == 0 {
 				c.logger.WithField("line", string(buf.Line)).Debug("trim space")
 				continue
 			}
 
+			// keep a local copy for reading logs, while elasticsearch is unavailable
+			if c.cache != nil {
+				if err := c.cache.Write(&buf.LogEntry); err != nil {
+					c.logger.WithError(err).Error("could not write to local cache")
+				}
+			}
+
 			select {
-			case c.pipeline.inputCh <- buf:
+			case inputCh <- buf:
 			case <-ctx.Done():
 				c.logger.WithError(ctx.Err()).Error("closing read pipeline: Read")
 				return ctx.Err()
@@ -129,26 +189,143 @@ func (c *container) Read(ctx context.Context) error {
 	return nil
 }
 
+// Partial reassembles log messages, which docker has split into several log entries
+func (c *container) Partial(ctx context.Context, maxSize int, timeout time.Duration) error {
+
+	c.logger.Debug("starting pipeline: Partial")
+
+	p := newPartial(maxSize, timeout)
+
+	inputCh := c.pipeline.inputCh
+	outputCh := make(chan logEntry)
+	c.pipeline.inputCh = outputCh
+
+	c.pipeline.group.Go(func() error {
+		defer close(outputCh)
+
+		ticker := time.NewTicker(timeout)
+		defer ticker.Stop()
+
+		send := func(entries []logEntry) error {
+			for _, entry := range entries {
+				select {
+				case outputCh <- entry:
+				case <-ctx.Done():
+					c.logger.WithError(ctx.Err()).Error("closing partial pipeline: Partial")
+					return ctx.Err()
+				}
+			}
+			return nil
+		}
+
+		for {
+			select {
+			case entry, open := <-inputCh:
+				if !open {
+					return send(p.Flush())
+				}
+				if err := send(p.Add(entry, time.Now())); err != nil {
+					return err
+				}
+			case now := <-ticker.C:
+				if err := send(p.Expire(now)); err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				c.logger.WithError(ctx.Err()).Error("closing partial pipeline: Partial")
+				return ctx.Err()
+			}
+		}
+	})
+
+	return nil
+}
+
+// Multiline merges continuation lines into a single log entry
+func (c *container) Multiline(ctx context.Context, pattern string, negate bool, match string, maxLines int, timeout time.Duration) error {
+
+	c.logger.Debug("starting pipeline: Multiline")
+
+	m, err := newMultiline(pattern, negate, match, maxLines, timeout)
+	if err != nil {
+		return err
+	}
+
+	inputCh := c.pipeline.inputCh
+	outputCh := make(chan logEntry)
+	c.pipeline.inputCh = outputCh
+
+	c.pipeline.group.Go(func() error {
+		defer close(outputCh)
+
+		ticker := time.NewTicker(timeout)
+		defer ticker.Stop()
+
+		send := func(entries []logdriver.LogEntry) error {
+			for _, entry := range entries {
+				select {
+				case outputCh <- logEntry{LogEntry: entry}:
+				case <-ctx.Done():
+					c.logger.WithError(ctx.Err()).Error("closing multiline pipeline: Multiline")
+					return ctx.Err()
+				}
+			}
+			return nil
+		}
+
+		for {
+			select {
+			case entry, open := <-inputCh:
+				if !open {
+					return send(m.Flush())
+				}
+				if err := send(m.Add(entry.LogEntry, time.Now())); err != nil {
+					return err
+				}
+			case now := <-ticker.C:
+				if err := send(m.Expire(now)); err != nil {
+					return err
+				}
+			case <-ctx.Done():
+				c.logger.WithError(ctx.Err()).Error("closing multiline pipeline: Multiline")
+				return ctx.Err()
+			}
+		}
+	})
+
+	return nil
+}
+
 // Parse filters line messages
-func (c *container) Parse(ctx context.Context, info logger.Info, fields, grokMatch, grokPattern, grokPatternFrom, grokPatternSplitter string, grokNamedCapture bool) error {
+func (c *container) Parse(ctx context.Context, info logger.Info, fields, grokMatch, grokPattern, grokPatternFrom, grokPatternSplitter string, grokNamedCapture, stripANSI bool) error {
 
 	c.logger.Debug("starting pipeline: Parse")
 
+	groker, err := grok.NewGrok(grokMatch, grokPattern, grokPatternFrom, grokPatternSplitter, grokNamedCapture)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.groker = groker
+	c.mu.Unlock()
+
+	inputCh := c.pipeline.inputCh
+
 	c.pipeline.group.Go(func() error {
 		defer close(c.pipeline.outputCh)
 
-		groker, err := grok.NewGrok(grokMatch, grokPattern, grokPatternFrom, grokPatternSplitter, grokNamedCapture)
-		if err != nil {
-			return err
-		}
-
+		var err error
 		var logMessage string
 		// custom log message fields
 		msg := getLogMessageFields(fields, info)
 
-		for m := range c.pipeline.inputCh {
+		for m := range inputCh {
 
-			logMessage = string(m.Line)
+			line := m.Line
+			if stripANSI {
+				line = regex.StripANSI(line)
+			}
+			logMessage = string(line)
 
 			// create message
 			msg.Source = m.Source
@@ -157,10 +334,13 @@ func (c *container) Parse(ctx context.Context, info logger.Info, fields, grokMat
 
 			// TODO: create a PR to grok upstream for parsing bytes
 			// so that we avoid having to convert the message to string
-			msg.GrokLine, msg.Line, err = groker.ParseLine(grokMatch, logMessage, m.Line)
+			groker, grokMatch := c.grok()
+			msg.GrokLine, msg.Line, err = groker.ParseLine(grokMatch, logMessage, line)
 			if err != nil {
 				c.logger.WithError(err).Error("could not parse line with grok")
+				c.metrics.GrokFailure()
 			}
+			c.metrics.LineParsed()
 
 			select {
 			case c.pipeline.outputCh <- msg:
@@ -178,10 +358,19 @@ func (c *container) Parse(ctx context.Context, info logger.Info, fields, grokMat
 }
 
 // Log sends messages to Elasticsearch Bulk Service
-func (c *container) Log(ctx context.Context, workers, actions, size int, flushInterval, timeout time.Duration, stats bool, indexName, tzpe string) error {
+func (c *container) Log(ctx context.Context, workers, actions, size int, timeout time.Duration, retries int, deadLetter string, tzpe, dataStream, idStrategy string) error {
 
 	c.logger.Debug("starting pipeline: Log")
 
+	c.mu.Lock()
+	containerID := c.info.ContainerID
+	c.mu.Unlock()
+
+	var spoolFn func([]string) error
+	if c.spool != nil {
+		spoolFn = c.spool.Write
+	}
+
 	c.pipeline.group.Go(func() error {
 
 		err := c.esClient.NewBulkProcessorService(
@@ -189,15 +378,22 @@ func (c *container) Log(ctx context.Context, workers, actions, size int, flushIn
 			workers,
 			actions,
 			size,
-			flushInterval,
+			// the flush interval is handled below, so that it can be changed at runtime
+			0,
 			timeout,
-			false,
+			retries,
+			deadLetter,
+			// the stats are exposed by the metrics
+			true,
+			spoolFn,
+			c.metrics,
 			c.logger,
 		)
 		if err != nil {
 			c.logger.WithError(err).Error("could not create bulk processor")
 			return err
 		}
+		c.metrics.SetStats(c.esClient.Stats)
 
 		defer func() {
 			if err := c.esClient.Flush(); err != nil {
@@ -209,23 +405,177 @@ func (c *container) Log(ctx context.Context, workers, actions, size int, flushIn
 			}
 		}()
 
-		for doc := range c.pipeline.outputCh {
-
-			c.esClient.Add(indexName, tzpe, doc)
+		var ticker *time.Ticker
+		var tickerCh <-chan time.Time
+		reset := func() {
+			if ticker != nil {
+				ticker.Stop()
+				ticker, tickerCh = nil, nil
+			}
+			if flushInterval := c.flushInterval(); flushInterval > 0 {
+				ticker = time.NewTicker(flushInterval)
+				tickerCh = ticker.C
+			}
+		}
+		reset()
+		defer func() {
+			if ticker != nil {
+				ticker.Stop()
+			}
+		}()
 
+		var sequence int64
+		for {
 			select {
+			case doc, open := <-c.pipeline.outputCh:
+				if !open {
+					return nil
+				}
+				// the sequence orders messages with the same timestamp
+				sequence++
+				doc.sequence = sequence
+				id := doc.documentID(idStrategy, containerID)
+				if dataStream != "" {
+					// data streams only accept create requests
+					doc.dataStream = true
+					c.esClient.Add(dataStream, tzpe, c.ingestPipeline(doc), "create", id, doc)
+				} else {
+					c.esClient.Add(c.index(doc), tzpe, c.ingestPipeline(doc), "index", id, doc)
+				}
+			case <-tickerCh:
+				if err := c.esClient.Flush(); err != nil {
+					c.logger.WithError(err).Error("could not flush queue")
+				}
+			case errCh := <-c.pipeline.flushCh:
+				errCh <- c.esClient.Flush()
+			case <-c.pipeline.reloadCh:
+				reset()
 			case <-ctx.Done():
 				c.logger.WithError(ctx.Err()).Error("closing log pipeline")
 				return ctx.Err()
-			default:
 			}
 		}
-		return nil
 	})
 
 	return nil
 }
 
+// Flush sends the queued messages to elasticsearch immediately
+func (c *container) Flush() error {
+	errCh := make(chan error, 1)
+	select {
+	case c.pipeline.flushCh <- errCh:
+	case <-c.done:
+		return errors.New("error: container stopped logging")
+	}
+	return <-errCh
+}
+
+// Update changes the settings of a running container. Only the index, the
+// routes, the ingest pipeline, the bulk flush interval and the grok settings
+// can be changed.
+func (c *container) Update(opts map[string]string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	config := c.config
+	if err := config.validateLogOpt(opts); err != nil {
+		return err
+	}
+
+	var reloadGrok, reloadFlush bool
+	for key := range opts {
+		switch key {
+		case "elasticsearch-index", "elasticsearch-route", "elasticsearch-pipeline":
+		case "elasticsearch-bulk-flush-interval":
+			reloadFlush = true
+		case "grok-pattern", "grok-pattern-from", "grok-pattern-splitter", "grok-match", "grok-named-capture":
+			reloadGrok = true
+		default:
+			return fmt.Errorf("error: log-opt cannot be changed at runtime: %s", key)
+		}
+	}
+
+	index, err := parseIndexTemplate(config.index, c.info)
+	if err != nil {
+		return err
+	}
+	routes, err := newRoutes(config.route, c.info)
+	if err != nil {
+		return err
+	}
+	pipelineNames, err := newPipelineNames(config.pipeline, c.info)
+	if err != nil {
+		return err
+	}
+
+	if reloadGrok {
+		groker, err := grok.NewGrok(config.grokMatch, config.grokPattern, config.grokPatternFrom, config.grokPatternSplitter, config.grokNamedCapture)
+		if err != nil {
+			return err
+		}
+		c.groker = groker
+	}
+
+	c.config = config
+	c.indexNames = newIndexNames(index)
+	c.routes = routes
+	c.pipelineNames = pipelineNames
+
+	if reloadFlush {
+		select {
+		case c.pipeline.reloadCh <- struct{}{}:
+		default:
+		}
+	}
+
+	c.logger.WithField("opts", opts).Info("changed logging config")
+
+	return nil
+}
+
+// index returns the index name of a document by its timestamp. The first
+// matching route is used, otherwise the default index.
+func (c *container) index(doc LogMessage) string {
+	t := time.Unix(0, doc.TimeNano).Local()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, r := range c.routes {
+		if r.Match(doc, &c.info) {
+			return r.indexNames.Name(t)
+		}
+	}
+	return c.indexNames.Name(t)
+}
+
+// ingestPipeline returns the ingest pipeline of a document by its timestamp
+func (c *container) ingestPipeline(doc LogMessage) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pipelineNames == nil {
+		return ""
+	}
+	return c.pipelineNames.Name(time.Unix(0, doc.TimeNano).Local())
+}
+
+func (c *container) grok() (grok.Grok, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.groker, c.config.grokMatch
+}
+
+func (c *container) flushInterval() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.config.Bulk.flushInterval
+}
+
 // BulkWorkerService interface
 type BulkWorkerService interface {
 	Flush(ctx context.Context)
@@ -268,20 +618,15 @@ func (b *BulkWorker) Commit(ctx context.Context) {
 
 	bulkResponse, _, rerr, err := b.Do(ctx)
 	if rerr {
-		// find out the reasons of the failure
-		if responses := b.Errors(bulkResponse); responses != nil {
-			for _, response := range responses {
-				for range response {
-					for status, reason := range response {
-						if status == 429 {
-							b.logger.WithFields(log.Fields{"reason": reason, "status": status}).Info("resending request")
-						} else {
-							b.logger.WithFields(log.Fields{"reason": reason, "status": status}).Info("response error message and status code")
-						}
-					}
+		// find out the reasons of the failure, the items rejected with
+		// 429 or 503 have been resent by the bulk service
+		for _, response := range b.Errors(bulkResponse) {
+			for status, reason := range response {
+				if resend.Retryable(status) {
+					continue
 				}
+				b.logger.WithFields(log.Fields{"reason": reason, "status": status}).Info("response error message and status code")
 			}
-			return
 		}
 	}
 	if err != nil {
@@ -366,28 +711,3 @@ func (b *BulkWorker) Commit(ctx context.Context) {
 
 // 	return nil
 // }
-
-// Stats shows metrics related to the bulk service
-// func (d *Driver) Stats(filename string, config Configuration) error {
-// TODO: create metrics from stats
-// d.pipeline.group.Go(func() error {
-// 	stats := d.esClient.Stats()
-
-// 	fields := log.Fields{
-// 		"flushed":   stats.Flushed,
-// 		"committed": stats.Committed,
-// 		"indexed":   stats.Indexed,
-// 		"created":   stats.Created,
-// 		"updated":   stats.Updated,
-// 		"succeeded": stats.Succeeded,
-// 		"failed":    stats.Failed,
-// 	}
-
-// 	for i, w := range stats.Workers {
-// 		fmt.Printf("Worker %d: Number of requests queued: %d\n", i, w.Queued)
-// 		fmt.Printf("           Last response time       : %v\n", w.LastDuration)
-// 		fields[fmt.Sprintf("w%d.queued", i)] = w.Queued
-// 		fields[fmt.Sprintf("w%d.lastduration", i)] = w.LastDuration
-// 	}
-// })
-// }
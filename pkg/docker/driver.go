@@ -2,37 +2,73 @@ package docker
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/robfig/cron"
+	log "github.com/Sirupsen/logrus"
 
 	"golang.org/x/sync/errgroup"
 
+	"github.com/docker/docker/api/types/plugins/logdriver"
 	"github.com/docker/docker/daemon/logger"
 
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/cache"
 	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch"
-	"github.com/rchicoli/docker-log-elasticsearch/pkg/regex"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/metrics"
 )
 
 const (
 	name = "elasticsearchlog"
+
+	// followInterval is the time to wait before polling for new log messages
+	followInterval = 1 * time.Second
+
+	// replayInterval is the time to wait before resending spooled requests
+	replayInterval = 10 * time.Second
 )
 
 // Driver ...
 type Driver struct {
 	mu   *sync.Mutex
 	logs map[string]*container
+	// cacheDir is the directory of the fifo files,
+	// where the local caches are kept as well
+	cacheDir string
+	// templates are the index templates installed per cluster
+	templates map[string]bool
+	// replayers resend the spooled requests per container directory
+	replayers map[string]*replayer
+	// spoolDirs are the spool-dirs, which have been looked up for
+	// the directories of other containers
+	spoolDirs map[string]bool
+}
+
+// ReadConfig is the configuration passed into ReadLogs. It mirrors
+// logger.ReadConfig, including the Until field of newer docker daemons.
+type ReadConfig struct {
+	Since  time.Time
+	Until  time.Time
+	Tail   int
+	Follow bool
 }
 
 // NewDriver returns a pointer to driver
 func NewDriver() *Driver {
 	return &Driver{
-		logs: make(map[string]*container),
-		mu:   new(sync.Mutex),
+		logs:      make(map[string]*container),
+		mu:        new(sync.Mutex),
+		cacheDir:  "/run/docker/logging",
+		templates: make(map[string]bool),
+		replayers: make(map[string]*replayer),
+		spoolDirs: make(map[string]bool),
 	}
 }
 
@@ -49,33 +85,146 @@ func (d *Driver) StartLogging(file string, info logger.Info) error {
 		return err
 	}
 
+	index, err := parseIndexTemplate(config.index, info)
+	if err != nil {
+		return err
+	}
+	routes, err := newRoutes(config.route, info)
+	if err != nil {
+		return err
+	}
+	pipelineNames, err := newPipelineNames(config.pipeline, info)
+	if err != nil {
+		return err
+	}
+	dataStream, err := parseDataStream(config.dataStream, info)
+	if err != nil {
+		return err
+	}
+
 	if d.containerExists(file) {
 		return fmt.Errorf("error: a logger for this container already exists: %s", file)
 	}
 
-	ctx := context.Background()
-	c, err := d.newContainer(ctx, file, info.ContainerID)
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := newContainer(ctx, file, info.ContainerID)
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	// the container is registered once it has been set up,
+	// otherwise its resources are released
+	started := false
+	defer func() {
+		if !started {
+			cancel()
+			c.abort()
+			if c.spool != nil {
+				d.closeSpool(c.spool)
+			}
+		}
+	}()
+
+	c.mu.Lock()
+	c.info = info
+	c.config = config
+	c.mu.Unlock()
+	c.metrics = metrics.New(info.ContainerID)
+
+	tlsConfig, err := config.tlsConfig()
 	if err != nil {
 		return err
 	}
 
-	c.esClient, err = elasticsearch.NewClient(config.version, config.url, config.username, config.password, config.timeout, config.sniff, config.insecure)
+	// the version of the cluster is requested once, the options are
+	// checked again, once the version has been detected
+	version := config.version
+	if version == "auto" || dataStream != "" {
+		cluster, err := elasticsearch.GetCluster(config.url, config.username, config.password, config.timeout, tlsConfig)
+		if err != nil {
+			return err
+		}
+		if version == "auto" {
+			if version, err = cluster.Version(); err != nil {
+				return err
+			}
+			if err := config.validateVersion(version); err != nil {
+				return err
+			}
+		}
+		// the fifo and the container are released by abort
+		if dataStream != "" {
+			if err := cluster.CheckDataStreams(); err != nil {
+				return err
+			}
+		}
+	}
+
+	c.esClient, err = elasticsearch.NewClient(version, config.url, config.username, config.password, config.timeout, config.sniff, tlsConfig, config.Bulk.oversize)
 	if err != nil {
 		return fmt.Errorf("error: cannot create an elasticsearch client: %v", err)
 	}
 
-	// org.elasticsearch.indices.InvalidIndexNameException: ... must be lowercase
-	c.indexName = strings.ToLower(regex.ParseDate(time.Now(), config.index))
-	if regex.IsValid(config.index) {
-		c.cron = cron.New()
-		c.cron.AddFunc("@daily", func() {
-			d.mu.Lock()
-			c.indexName = strings.ToLower(regex.ParseDate(time.Now(), config.index))
-			d.mu.Unlock()
+	if config.template {
+		patterns := indexPatterns(index, routes)
+		if dataStream != "" {
+			patterns = []string{dataStream}
+		}
+		d.installTemplate(c.logger, config, version, tlsConfig, elasticsearch.Template{
+			Name:        config.templateName,
+			Patterns:    patterns,
+			Type:        config.tzpe,
+			Shards:      config.templateShards,
+			Replicas:    config.templateReplicas,
+			DataStream:  dataStream != "",
+			Policy:      config.ilmPolicy,
+			RolloverAge: config.ilmRolloverAge,
+			DeleteAge:   config.ilmDeleteAge,
+		})
+	}
+
+	// the original documents are not indexed in the dead-letter index
+	if config.deadLetterIndex != "" {
+		d.installTemplate(c.logger, config, version, tlsConfig, elasticsearch.Template{
+			Name:       config.deadLetterIndex,
+			Patterns:   []string{config.deadLetterIndex},
+			Type:       config.tzpe,
+			Shards:     config.templateShards,
+			Replicas:   config.templateReplicas,
+			DeadLetter: true,
 		})
-		c.cron.Start()
 	}
 
+	if config.localCacheMaxSize > 0 {
+		d.mu.Lock()
+		d.cacheDir = path.Dir(file)
+		d.mu.Unlock()
+
+		c.cache, err = cache.New(d.cacheFile(info.ContainerID), config.localCacheMaxSize)
+		if err != nil {
+			return fmt.Errorf("error: cannot create local cache: %v", err)
+		}
+	}
+
+	// the spool is replayed by a client of its own,
+	// because it outlives the container
+	if config.spoolDir != "" {
+		c.spool, err = d.openSpool(config, info.ContainerID, func() (elasticsearch.Client, error) {
+			return elasticsearch.NewClient(version, config.url, config.username, config.password, config.timeout, config.sniff, tlsConfig, config.Bulk.oversize)
+		})
+		if err != nil {
+			return fmt.Errorf("error: cannot create spool: %v", err)
+		}
+	}
+
+	// the index name is resolved per document by its timestamp
+	c.mu.Lock()
+	c.indexNames = newIndexNames(index)
+	c.routes = routes
+	c.pipelineNames = pipelineNames
+	c.mu.Unlock()
+
 	var pctx context.Context
 	c.pipeline.group, pctx = errgroup.WithContext(ctx)
 
@@ -84,12 +233,26 @@ func (d *Driver) StartLogging(file string, info logger.Info) error {
 		return err
 	}
 
-	if err := c.Parse(pctx, info, config.fields, config.grokMatch, config.grokPattern, config.grokPatternFrom, config.grokPatternSplitter, config.grokNamedCapture); err != nil {
+	if config.partialReassemble {
+		if err := c.Partial(pctx, config.partialMaxSize, config.partialTimeout); err != nil {
+			c.logger.WithError(err).Error("could not reassemble partial messages")
+			return err
+		}
+	}
+
+	if config.multilinePattern != "" {
+		if err := c.Multiline(pctx, config.multilinePattern, config.multilineNegate, config.multilineMatch, config.multilineMaxLines, config.multilineTimeout); err != nil {
+			c.logger.WithError(err).Error("could not merge multiline messages")
+			return err
+		}
+	}
+
+	if err := c.Parse(pctx, info, config.fields, config.grokMatch, config.grokPattern, config.grokPatternFrom, config.grokPatternSplitter, config.grokNamedCapture, config.stripANSI); err != nil {
 		c.logger.WithError(err).Error("could not parse line message")
 		return err
 	}
 
-	if err := c.Log(pctx, config.Bulk.workers, config.Bulk.actions, config.Bulk.size, config.Bulk.flushInterval, config.timeout, false, c.indexName, config.tzpe); err != nil {
+	if err := c.Log(pctx, config.Bulk.workers, config.Bulk.actions, config.Bulk.size, config.timeout, config.Bulk.retries, config.deadLetterIndex, config.tzpe, dataStream, config.idStrategy); err != nil {
 		c.logger.WithError(err).Error("could not log to elasticsearch")
 		return err
 	}
@@ -99,10 +262,37 @@ func (d *Driver) StartLogging(file string, info logger.Info) error {
 	// 	return err
 	// }
 
+	d.addContainer(file, c)
+	started = true
+
 	return nil
 
 }
 
+// installTemplate installs the index template on the first start of a container
+// logging to the cluster. Failures are logged, so that the next container retries.
+func (d *Driver) installTemplate(logger *log.Entry, config Configuration, version string, tlsConfig *tls.Config, t elasticsearch.Template) {
+
+	key := config.url + "/" + t.Name
+	d.mu.Lock()
+	installed := d.templates[key]
+	d.mu.Unlock()
+	if installed {
+		return
+	}
+
+	err := elasticsearch.InstallTemplate(version, config.url, config.username, config.password, config.timeout, tlsConfig, t)
+	if err != nil {
+		logger.WithError(err).Error("could not install index template")
+		return
+	}
+	logger.WithField("template", t.Name).Info("installed index template")
+
+	d.mu.Lock()
+	d.templates[key] = true
+	d.mu.Unlock()
+}
+
 // StopLogging implements the docker plugin interface
 func (d *Driver) StopLogging(file string) error {
 
@@ -128,10 +318,6 @@ func (d *Driver) StopLogging(file string) error {
 		c.stream.Close()
 	}
 
-	if c.cron != nil {
-		c.cron.Stop()
-	}
-
 	if c.pipeline.group != nil {
 		c.logger.Info("closing pipeline")
 
@@ -146,36 +332,342 @@ func (d *Driver) StopLogging(file string) error {
 		c.esClient.Stop()
 	}
 
+	if c.cache != nil {
+		if err := c.cache.Close(); err != nil {
+			c.logger.WithError(err).Error("could not close local cache")
+		}
+	}
+
+	// the bulk processor has been closed, nothing is spooled anymore
+	if c.spool != nil {
+		d.closeSpool(c.spool)
+	}
+
+	metrics.Remove(c.containerID)
+
+	// notify all readers following the logs
+	close(c.done)
+
 	return nil
 
 }
 
-func (d *Driver) containerExists(file string) bool {
-	filename := path.Base(file)
+// ReadLogs implements the docker plugin interface
+func (d *Driver) ReadLogs(info logger.Info, readConfig ReadConfig) (io.ReadCloser, error) {
+
+	config := newConfiguration()
+	if err := config.validateLogOpt(info.Config); err != nil {
+		return nil, err
+	}
+
+	// documents are looked up by the container ID
+	if !hasField(config.fields, "containerID") {
+		return nil, errors.New("error: reading logs requires containerID in elasticsearch-fields")
+	}
+
+	var cacheFile string
+	if config.localCacheMaxSize > 0 {
+		cacheFile = d.cacheFile(info.ContainerID)
+	}
+
+	tlsConfig, err := config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	esClient, err := elasticsearch.NewClient(config.version, config.url, config.username, config.password, config.timeout, config.sniff, tlsConfig, config.Bulk.oversize)
+	if err != nil {
+		if cacheFile == "" {
+			return nil, fmt.Errorf("error: cannot create an elasticsearch client: %v", err)
+		}
+		log.WithField("containerID", info.ID()).WithError(err).Warn("reading logs from local cache")
+		esClient = nil
+	}
+
+	pattern, err := parseIndexTemplate(config.index, info)
+	if err != nil {
+		return nil, err
+	}
+	routes, err := newRoutes(config.route, info)
+	if err != nil {
+		return nil, err
+	}
+
+	dataStream, err := parseDataStream(config.dataStream, info)
+	if err != nil {
+		return nil, err
+	}
+
+	// search all indices created by the date patterns
+	index := strings.Join(indexPatterns(pattern, routes), ",")
+	if dataStream != "" {
+		index = dataStream
+	}
+
+	// only running containers are followed until they stop logging
+	var stopped <-chan struct{}
+	if readConfig.Follow && esClient != nil {
+		if esClient.Version() < 5 {
+			esClient.Stop()
+			return nil, fmt.Errorf("error: following logs requires elasticsearch version 5 or above: %s", config.version)
+		}
+		if c, exists := d.getContainerByID(info.ContainerID); exists {
+			stopped = c.done
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r, w := io.Pipe()
+
+	go func() {
+		if esClient != nil {
+			defer esClient.Stop()
+		}
+
+		var err error
+		var cursor []interface{}
+		// timestamp of the last message read from elasticsearch
+		var last int64
+		// the last messages are kept in memory, because messages
+		// of the local cache, which are not indexed yet, may replace them
+		var tail []logdriver.LogEntry
+
+		enc := logdriver.NewLogEntryEncoder(w)
+		encode := func(entry logdriver.LogEntry) error {
+			if readConfig.Tail > 0 && cacheFile != "" && stopped == nil {
+				tail = append(tail, entry)
+				return nil
+			}
+			return enc.Encode(&entry)
+		}
+
+		// a tail of zero skips all previous log messages
+		if esClient != nil && readConfig.Tail != 0 {
+			err = esClient.Read(ctx, index, info.ID(), readConfig.Since, readConfig.Until, readConfig.Tail, func(source []byte, sort []interface{}) error {
+				entry, err := unmarshalLogEntry(source)
+				if err != nil {
+					return err
+				}
+				cursor = sort
+				last = entry.TimeNano
+				return encode(entry)
+			})
+		}
+
+		// serve the messages from the local cache, which could not be read
+		// from elasticsearch. Followers receive them, once they are indexed.
+		if cacheFile != "" && readConfig.Tail != 0 && (err != nil || stopped == nil) && err != io.ErrClosedPipe && err != context.Canceled {
+			if err != nil {
+				log.WithField("containerID", info.ID()).WithError(err).Warn("reading logs from local cache")
+			}
+			err = cache.Read(cacheFile, func(entry *logdriver.LogEntry) error {
+				timestamp := time.Unix(0, entry.TimeNano)
+				if entry.TimeNano <= last || timestamp.Before(readConfig.Since) || !readConfig.Until.IsZero() && timestamp.After(readConfig.Until) {
+					return nil
+				}
+				return encode(*entry)
+			})
+			if err == nil && len(tail) > 0 {
+				if len(tail) > readConfig.Tail {
+					tail = tail[len(tail)-readConfig.Tail:]
+				}
+				for i := range tail {
+					if err = enc.Encode(&tail[i]); err != nil {
+						break
+					}
+				}
+			}
+		}
+
+		if err == nil && stopped != nil {
+			since := readConfig.Since
+			if cursor == nil && readConfig.Tail == 0 {
+				since = time.Now()
+			}
+			err = follow(ctx, esClient, index, info.ID(), since, readConfig.Until, cursor, stopped, func(source []byte, sort []interface{}) error {
+				entry, err := unmarshalLogEntry(source)
+				if err != nil {
+					return err
+				}
+				return enc.Encode(&entry)
+			})
+		}
+
+		if err != nil && err != io.ErrClosedPipe && err != context.Canceled {
+			log.WithField("containerID", info.ID()).WithError(err).Error("could not read logs")
+		}
+		w.CloseWithError(err)
+	}()
+
+	return &logStream{PipeReader: r, cancel: cancel}, nil
+}
+
+// follow polls elasticsearch for new log messages, starting after the
+// given sort values, until the container stops logging. The sort values
+// are the timestamp and the sequence number of the last message, hence
+// messages with the same timestamp, which are indexed later, are not skipped.
+func follow(ctx context.Context, esClient elasticsearch.Client, index, containerID string, since, until time.Time, cursor []interface{}, stopped <-chan struct{}, fn func([]byte, []interface{}) error) error {
+
+	next := func(source []byte, sort []interface{}) error {
+		cursor = sort
+		return fn(source, sort)
+	}
+
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stopped:
+			// the pipeline has been flushed already, wait until
+			// the last messages are searchable before returning
+			select {
+			case <-time.After(followInterval):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return esClient.SearchAfter(ctx, index, containerID, since, until, cursor, next)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		if err := esClient.SearchAfter(ctx, index, containerID, since, until, cursor, next); err != nil {
+			return err
+		}
+
+		if !until.IsZero() && time.Now().After(until) {
+			return nil
+		}
+	}
+}
+
+// logStream stops reading from elasticsearch, once the stream is closed
+type logStream struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+// Close cancels any pending request and closes the pipe
+func (s *logStream) Close() error {
+	s.cancel()
+	return s.PipeReader.Close()
+}
+
+// ContainerStatus describes a container, which is logging to elasticsearch
+type ContainerStatus struct {
+	ContainerID   string            `json:"containerID"`
+	ContainerName string            `json:"containerName"`
+	File          string            `json:"file"`
+	Config        map[string]string `json:"config"`
+}
+
+// ErrContainerNotFound is returned, if no container with the given ID is logging
+var ErrContainerNotFound = errors.New("error: container not found")
+
+// Containers returns the containers with their effective configuration,
+// secrets are masked
+func (d *Driver) Containers() []ContainerStatus {
+
 	d.mu.Lock()
-	if _, exists := d.logs[filename]; exists {
-		return true
+	containers := make([]*container, 0, len(d.logs))
+	for _, c := range d.logs {
+		containers = append(containers, c)
 	}
 	d.mu.Unlock()
-	return false
+
+	statuses := make([]ContainerStatus, 0, len(containers))
+	for _, c := range containers {
+		c.mu.Lock()
+		statuses = append(statuses, ContainerStatus{
+			ContainerID:   c.containerID,
+			ContainerName: c.info.Name(),
+			File:          c.file,
+			Config:        c.config.logOpts(),
+		})
+		c.mu.Unlock()
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ContainerID < statuses[j].ContainerID })
+
+	return statuses
 }
 
-// newContainer stores the container's configuration in memory
-// and returns a pointer to the container
-func (d *Driver) newContainer(ctx context.Context, file, containerID string) (*container, error) {
+// Flush sends the queued messages of a container to elasticsearch immediately
+func (d *Driver) Flush(containerID string) error {
+	c, exists := d.getContainerByID(containerID)
+	if !exists {
+		return ErrContainerNotFound
+	}
+	return c.Flush()
+}
 
+// Update changes the log-opts of a running container
+func (d *Driver) Update(containerID string, opts map[string]string) error {
+	c, exists := d.getContainerByID(containerID)
+	if !exists {
+		return ErrContainerNotFound
+	}
+	return c.Update(opts)
+}
+
+func (d *Driver) containerExists(file string) bool {
 	filename := path.Base(file)
+	d.mu.Lock()
+	defer d.mu.Unlock()
 
-	c, err := newContainer(ctx, file, containerID)
+	_, exists := d.logs[filename]
+	return exists
+}
+
+// addContainer stores the container's configuration in memory
+func (d *Driver) addContainer(file string, c *container) {
+	d.mu.Lock()
+	d.logs[path.Base(file)] = c
+	d.mu.Unlock()
+}
+
+// PruneCaches deletes the local caches left in the cache directory, e.g. of
+// containers, which have been removed. The plugin is not notified, when a
+// container is removed, hence it must be called before containers start logging.
+func (d *Driver) PruneCaches() error {
+	d.mu.Lock()
+	dir := d.cacheDir
+	d.mu.Unlock()
+
+	files, err := filepath.Glob(path.Join(dir, "*.cache"))
 	if err != nil {
-		return nil, err
+		return err
 	}
+	for _, file := range files {
+		if err := cache.Remove(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
+// cacheFile returns the path of the container's local cache
+func (d *Driver) cacheFile(containerID string) string {
 	d.mu.Lock()
-	d.logs[filename] = c
-	d.mu.Unlock()
+	defer d.mu.Unlock()
 
-	return c, nil
+	return path.Join(d.cacheDir, containerID+".cache")
+}
+
+// getContainerByID retrieves a running container by its ID
+func (d *Driver) getContainerByID(containerID string) (*container, bool) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, c := range d.logs {
+		if c.containerID == containerID {
+			return c, true
+		}
+	}
+
+	return nil, false
 }
 
 // getContainer retrieves the container's configuration from memory
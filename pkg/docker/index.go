@@ -0,0 +1,158 @@
+package docker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/regex"
+)
+
+// indexCacheSize is the number of index names kept in the cache
+const indexCacheSize = 8
+
+// indexNames resolves the index name of a document by its own timestamp,
+// so that late or replayed messages are written to the index of their day.
+// The names are cached per rotation period, e.g. per hour or per week,
+// hence the pattern is seldom parsed. Weeks mixed with years or months
+// are cached per day, because a week may span two of them.
+type indexNames struct {
+	pattern   string
+	rotation  regex.Rotation
+	lowercase bool
+	names     map[int64]string
+}
+
+func newIndexNames(pattern string) *indexNames {
+	return &indexNames{
+		pattern:   pattern,
+		rotation:  regex.ParseRotation(pattern),
+		lowercase: true,
+		names:     make(map[int64]string, indexCacheSize),
+	}
+}
+
+// newPipelineNames evaluates the template of an ingest pipeline, whose name
+// is resolved by the timestamp of the documents like the index name. Pipeline
+// IDs are case sensitive. Nil is returned, if no pipeline is used.
+func newPipelineNames(pattern string, info logger.Info) (*indexNames, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	pipeline, err := executeTemplate("elasticsearch-pipeline", pattern, info)
+	if err != nil {
+		return nil, err
+	}
+	n := newIndexNames(pipeline)
+	n.lowercase = false
+	return n, nil
+}
+
+// Name returns the index name of a timestamp
+func (n *indexNames) Name(t time.Time) string {
+	start := n.rotation.Start(t).Unix()
+	if name, exists := n.names[start]; exists {
+		return name
+	}
+
+	if len(n.names) >= indexCacheSize {
+		n.names = make(map[int64]string, indexCacheSize)
+	}
+
+	name := regex.ParseDate(t, n.pattern)
+	if n.lowercase {
+		// org.elasticsearch.indices.InvalidIndexNameException: ... must be lowercase
+		name = strings.ToLower(name)
+	}
+	n.names[start] = name
+	return name
+}
+
+// parseDataStream evaluates the template of the data stream name,
+// which must be lowercase like index names
+func parseDataStream(name string, info logger.Info) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	name, err := parseIndexTemplate(name, info)
+	return strings.ToLower(name), err
+}
+
+// indexPatterns returns the wildcards of the index and of the routes,
+// which match all indices created by their date sequences
+func indexPatterns(index string, routes []route) []string {
+	patterns := []string{strings.ToLower(regex.Wildcard(index))}
+	for _, r := range routes {
+		patterns = append(patterns, strings.ToLower(regex.Wildcard(r.indexNames.pattern)))
+	}
+	return patterns
+}
+
+// invalidIndexChars replaces the characters, which elasticsearch forbids in index names
+var invalidIndexChars = strings.NewReplacer(
+	`\`, "_", "/", "_", "*", "_", "?", "_", `"`, "_", "<", "_", ">", "_",
+	"|", "_", " ", "_", ",", "_", "#", "_", ":", "_",
+)
+
+// indexInfo exposes the container metadata to index templates
+type indexInfo struct {
+	*logger.Info
+}
+
+// ContainerName returns the name of the container without the leading slash
+func (i indexInfo) ContainerName() string {
+	return i.Info.Name()
+}
+
+// ContainerLabels returns the value of a container label
+func (i indexInfo) ContainerLabels(key string) string {
+	return i.Info.ContainerLabels[key]
+}
+
+// ContainerEnv returns the value of an environment variable of the container
+func (i indexInfo) ContainerEnv(key string) string {
+	for _, env := range i.Info.ContainerEnv {
+		if strings.HasPrefix(env, key+"=") {
+			return env[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// parseIndexTemplate evaluates the go template of an index pattern against
+// the container metadata, the date sequences are kept. Characters, which are
+// forbidden in index names, are replaced by an underscore.
+func parseIndexTemplate(pattern string, info logger.Info) (string, error) {
+	if !strings.Contains(pattern, "{{") {
+		return pattern, nil
+	}
+
+	index, err := executeTemplate("elasticsearch-index", pattern, info)
+	if err != nil {
+		return "", err
+	}
+
+	// index names must not start with -, _ or +
+	index = strings.TrimLeft(invalidIndexChars.Replace(index), "-_+")
+	if index == "" {
+		return "", errors.New("error: elasticsearch-index is empty")
+	}
+	return index, nil
+}
+
+// executeTemplate evaluates the go template of a log-opt against the container metadata
+func executeTemplate(opt, pattern string, info logger.Info) (string, error) {
+	tmpl, err := template.New(opt).Parse(pattern)
+	if err != nil {
+		return "", fmt.Errorf("error: parsing %s: %q", opt, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, indexInfo{&info}); err != nil {
+		return "", fmt.Errorf("error: executing %s: %q", opt, err)
+	}
+	return buf.String(), nil
+}
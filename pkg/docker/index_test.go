@@ -0,0 +1,107 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func Test_indexNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		time    time.Time
+		want    string
+	}{
+		{name: "start of day", pattern: "Docker-%Y.%m.%d", time: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), want: "docker-2018.03.01"},
+		{name: "end of day", pattern: "Docker-%Y.%m.%d", time: time.Date(2018, 3, 1, 23, 59, 59, 0, time.UTC), want: "docker-2018.03.01"},
+		{name: "next day", pattern: "Docker-%Y.%m.%d", time: time.Date(2018, 3, 2, 0, 0, 0, 0, time.UTC), want: "docker-2018.03.02"},
+		{name: "late message", pattern: "Docker-%Y.%m.%d", time: time.Date(2018, 2, 28, 12, 0, 0, 0, time.UTC), want: "docker-2018.02.28"},
+		{name: "no date", pattern: "docker", time: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), want: "docker"},
+		{name: "hourly", pattern: "docker-%F.%H", time: time.Date(2018, 3, 1, 10, 59, 0, 0, time.UTC), want: "docker-2018.03.01.10"},
+		{name: "next hour", pattern: "docker-%F.%H", time: time.Date(2018, 3, 1, 11, 0, 0, 0, time.UTC), want: "docker-2018.03.01.11"},
+		{name: "weekly", pattern: "docker-%G.%V", time: time.Date(2018, 3, 4, 23, 0, 0, 0, time.UTC), want: "docker-2018.09"},
+		{name: "next week", pattern: "docker-%G.%V", time: time.Date(2018, 3, 5, 0, 0, 0, 0, time.UTC), want: "docker-2018.10"},
+		{name: "week of the old year", pattern: "logs-%Y.%V", time: time.Date(2025, 12, 31, 12, 0, 0, 0, time.UTC), want: "logs-2025.01"},
+		{name: "week of the new year", pattern: "logs-%Y.%V", time: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC), want: "logs-2026.01"},
+		{name: "week of the old month", pattern: "logs-%Y.%m-w%V", time: time.Date(2018, 2, 28, 12, 0, 0, 0, time.UTC), want: "logs-2018.02-w09"},
+		{name: "week of the new month", pattern: "logs-%Y.%m-w%V", time: time.Date(2018, 3, 1, 12, 0, 0, 0, time.UTC), want: "logs-2018.03-w09"},
+	}
+	n := newIndexNames("Docker-%Y.%m.%d")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.pattern != n.pattern {
+				n = newIndexNames(tt.pattern)
+			}
+			if got := n.Name(tt.time); got != tt.want {
+				t.Errorf("Name() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseIndexTemplate(t *testing.T) {
+	info := logger.Info{
+		ContainerName:   "/web.1",
+		ContainerLabels: map[string]string{"team": "Payments/EU", "com.docker.compose.project": "shop"},
+		ContainerEnv:    []string{"STAGE=prod"},
+	}
+	tests := []struct {
+		name    string
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{name: "date pattern", pattern: "docker-%Y.%m.%d", want: "docker-%Y.%m.%d"},
+		{name: "labels", pattern: `logs-{{.ContainerLabels "team"}}-{{.ContainerName}}-%Y.%m.%d`, want: "logs-Payments_EU-web.1-%Y.%m.%d"},
+		{name: "compose project", pattern: `{{.ContainerLabels "com.docker.compose.project"}}-%F`, want: "shop-%F"},
+		{name: "env", pattern: `{{.ContainerEnv "STAGE"}}-logs`, want: "prod-logs"},
+		{name: "leading characters", pattern: `{{.ContainerLabels "missing"}}-logs`, want: "logs"},
+		{name: "empty", pattern: `{{.ContainerLabels "missing"}}`, wantErr: true},
+		{name: "unknown field", pattern: `{{.Unknown}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIndexTemplate(tt.pattern, info)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseIndexTemplate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseIndexTemplate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newPipelineNames(t *testing.T) {
+	info := logger.Info{ContainerLabels: map[string]string{"app": "Nginx"}}
+	tests := []struct {
+		name    string
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{name: "none", pattern: ""},
+		{name: "case sensitive", pattern: "Parse-Logs", want: "Parse-Logs"},
+		{name: "template and date", pattern: `{{.ContainerLabels "app"}}-%Y`, want: "Nginx-2018"},
+		{name: "invalid template", pattern: `{{.Unknown}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := newPipelineNames(tt.pattern, info)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newPipelineNames() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			var got string
+			if n != nil {
+				got = n.Name(time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC))
+			}
+			if got != tt.want {
+				t.Errorf("Name() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,11 +1,16 @@
 package docker
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/docker/docker/api/types/plugins/logdriver"
 	"github.com/docker/docker/daemon/logger"
+	"github.com/google/uuid"
 )
 
 // LogMessage ...
@@ -14,6 +19,12 @@ type LogMessage struct {
 	logger.Info
 
 	GrokLine map[string]string
+
+	// dataStream adds the @timestamp field required by data streams
+	dataStream bool
+	// sequence numbers the messages of a container, it orders the
+	// messages with the same timestamp while reading them
+	sequence int64
 }
 
 // MarshalJSON ...
@@ -36,10 +47,12 @@ func (l LogMessage) MarshalJSON() ([]byte, error) {
 			DaemonName          string            `json:"daemonName,omitempty"`
 
 			//  api/types/plugin/logdriver/LogEntry
-			Line     string    `json:"message,omitempty"` // []byte to string
-			Source   string    `json:"source"`
-			TimeNano time.Time `json:"timestamp"` // int64 to Time
-			Partial  bool      `json:"partial"`
+			Line      string     `json:"message,omitempty"` // []byte to string
+			Source    string     `json:"source"`
+			TimeNano  time.Time  `json:"timestamp"` // int64 to Time
+			Timestamp *time.Time `json:"@timestamp,omitempty"`
+			Partial   bool       `json:"partial"`
+			Sequence  int64      `json:"sequence,omitempty"`
 
 			GrokLine map[string]string `json:"grok,omitempty"`
 		}{
@@ -62,10 +75,50 @@ func (l LogMessage) MarshalJSON() ([]byte, error) {
 			Source:   l.Source,
 			TimeNano: time.Unix(0, l.TimeNano).Local(),
 			Partial:  l.Partial,
+			Sequence: l.sequence,
+
+			Timestamp: l.dataStreamTimestamp(),
 		})
 
 }
 
+// documentID returns the _id of the log message by the id strategy. Hashes
+// are the same for every attempt, so that resent messages are not duplicated.
+// The id is empty for none, then elasticsearch assigns one.
+func (l LogMessage) documentID(strategy, containerID string) string {
+	switch strategy {
+	case "hash":
+		h := sha256.New()
+		for _, v := range []string{containerID, strconv.FormatInt(l.TimeNano, 10), l.Source, string(l.Line)} {
+			h.Write([]byte(v))
+			h.Write([]byte{0})
+		}
+		// the line is moved to the grok fields, if it has been parsed
+		keys := make([]string, 0, len(l.GrokLine))
+		for k := range l.GrokLine {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			h.Write([]byte(k + "=" + l.GrokLine[k]))
+			h.Write([]byte{0})
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	case "none":
+		return ""
+	default:
+		return uuid.New().String()
+	}
+}
+
+func (l LogMessage) dataStreamTimestamp() *time.Time {
+	if !l.dataStream {
+		return nil
+	}
+	t := time.Unix(0, l.TimeNano).Local()
+	return &t
+}
+
 func (l LogMessage) timeOmityEmpty() *time.Time {
 	if l.ContainerCreated.IsZero() {
 		return nil
@@ -73,3 +126,46 @@ func (l LogMessage) timeOmityEmpty() *time.Time {
 	t := l.ContainerCreated.Local()
 	return &t
 }
+
+// logDocument is the part of a LogMessage required to
+// recreate the docker log entry from elasticsearch
+type logDocument struct {
+	Line     string            `json:"message"`
+	Source   string            `json:"source"`
+	TimeNano time.Time         `json:"timestamp"`
+	Partial  bool              `json:"partial"`
+	GrokLine map[string]string `json:"grok"`
+}
+
+// unmarshalLogEntry converts an indexed LogMessage back to a docker log entry
+func unmarshalLogEntry(source []byte) (logdriver.LogEntry, error) {
+	var doc logDocument
+	if err := json.Unmarshal(source, &doc); err != nil {
+		return logdriver.LogEntry{}, err
+	}
+
+	line := []byte(doc.Line)
+	// the message is not indexed, if the line has been parsed by grok
+	if len(line) == 0 && doc.GrokLine != nil {
+		if l, exists := doc.GrokLine["line"]; exists {
+			line = []byte(l)
+		} else {
+			grokLine, err := json.Marshal(doc.GrokLine)
+			if err != nil {
+				return logdriver.LogEntry{}, err
+			}
+			line = grokLine
+		}
+	}
+	// docker strips the newline before sending it to the log driver
+	if !doc.Partial {
+		line = append(line, '\n')
+	}
+
+	return logdriver.LogEntry{
+		Source:   doc.Source,
+		TimeNano: doc.TimeNano.UnixNano(),
+		Line:     line,
+		Partial:  doc.Partial,
+	}, nil
+}
@@ -0,0 +1,101 @@
+package docker
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func Test_unmarshalLogEntry(t *testing.T) {
+	timestamp := time.Date(2018, 3, 16, 22, 13, 42, 810856646, time.UTC)
+
+	tests := []struct {
+		name    string
+		source  string
+		want    string
+		wantErr bool
+	}{
+		{name: "message", source: `{"message":"this is a test","source":"stdout","timestamp":"2018-03-16T22:13:42.810856646Z","partial":false}`, want: "this is a test\n"},
+		{name: "partial", source: `{"message":"this is a test","source":"stdout","timestamp":"2018-03-16T22:13:42.810856646Z","partial":true}`, want: "this is a test"},
+		{name: "grok failure", source: `{"source":"stdout","timestamp":"2018-03-16T22:13:42.810856646Z","grok":{"line":"this is a test","err":"grok pattern does not match line"}}`, want: "this is a test\n"},
+		{name: "grok", source: `{"source":"stdout","timestamp":"2018-03-16T22:13:42.810856646Z","grok":{"user":"tester"}}`, want: "{\"user\":\"tester\"}\n"},
+		{name: "invalid json", source: `{"message":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unmarshalLogEntry([]byte(tt.source))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unmarshalLogEntry() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if string(got.Line) != tt.want {
+				t.Errorf("unmarshalLogEntry() line = %q, want %q", got.Line, tt.want)
+			}
+			if got.TimeNano != timestamp.UnixNano() {
+				t.Errorf("unmarshalLogEntry() timeNano = %v, want %v", got.TimeNano, timestamp.UnixNano())
+			}
+			if got.Source != "stdout" {
+				t.Errorf("unmarshalLogEntry() source = %v, want stdout", got.Source)
+			}
+		})
+	}
+}
+
+func TestLogMessage_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name       string
+		dataStream bool
+	}{
+		{name: "index", dataStream: false},
+		{name: "data stream", dataStream: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := LogMessage{dataStream: tt.dataStream}
+			msg.TimeNano = time.Date(2018, 3, 16, 22, 13, 42, 0, time.UTC).UnixNano()
+
+			data, err := json.Marshal(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var doc map[string]interface{}
+			if err := json.Unmarshal(data, &doc); err != nil {
+				t.Fatal(err)
+			}
+			if _, exists := doc["@timestamp"]; exists != tt.dataStream {
+				t.Errorf("MarshalJSON() = %s, want @timestamp %v", data, tt.dataStream)
+			}
+			if doc["@timestamp"] != nil && doc["@timestamp"] != doc["timestamp"] {
+				t.Errorf("MarshalJSON() @timestamp = %v, want %v", doc["@timestamp"], doc["timestamp"])
+			}
+		})
+	}
+}
+
+func TestLogMessage_documentID(t *testing.T) {
+	msg := LogMessage{}
+	msg.TimeNano = time.Date(2018, 3, 16, 22, 13, 42, 0, time.UTC).UnixNano()
+	msg.Source = "stdout"
+	msg.Line = []byte("this is a test")
+
+	other := msg
+	other.Source = "stderr"
+
+	if got := msg.documentID("hash", "abc"); got != msg.documentID("hash", "abc") {
+		t.Errorf("documentID(hash) = %v, want the same id for the same message", got)
+	}
+	if msg.documentID("hash", "abc") == other.documentID("hash", "abc") {
+		t.Errorf("documentID(hash) = %v, want different ids for different sources", other.documentID("hash", "abc"))
+	}
+	if msg.documentID("hash", "abc") == msg.documentID("hash", "def") {
+		t.Errorf("documentID(hash) = %v, want different ids for different containers", msg.documentID("hash", "def"))
+	}
+	if msg.documentID("uuid", "abc") == msg.documentID("uuid", "abc") {
+		t.Errorf("documentID(uuid) = %v, want a new id on every call", msg.documentID("uuid", "abc"))
+	}
+	if got := msg.documentID("none", "abc"); got != "" {
+		t.Errorf("documentID(none) = %v, want empty", got)
+	}
+}
@@ -0,0 +1,108 @@
+package docker
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+const (
+	// multilineAfter appends continuation lines to the previous line
+	multilineAfter = "after"
+	// multilineBefore prepends continuation lines to the next line
+	multilineBefore = "before"
+)
+
+// multiline merges continuation lines, e.g. stack traces, into a single
+// log entry. Lines of stdout and stderr are merged separately.
+type multiline struct {
+	pattern  *regexp.Regexp
+	negate   bool
+	match    string
+	maxLines int
+	timeout  time.Duration
+
+	events map[string]*multilineEvent
+}
+
+type multilineEvent struct {
+	entry   logdriver.LogEntry
+	lines   int
+	updated time.Time
+}
+
+func newMultiline(pattern string, negate bool, match string, maxLines int, timeout time.Duration) (*multiline, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &multiline{
+		pattern:  re,
+		negate:   negate,
+		match:    match,
+		maxLines: maxLines,
+		timeout:  timeout,
+		events:   make(map[string]*multilineEvent),
+	}, nil
+}
+
+// Add merges a log entry and returns the log entries, which are complete
+func (m *multiline) Add(entry logdriver.LogEntry, now time.Time) []logdriver.LogEntry {
+	var complete []logdriver.LogEntry
+
+	continuation := m.pattern.Match(entry.Line) != m.negate
+	event, exists := m.events[entry.Source]
+
+	if m.match == multilineAfter && exists && !continuation {
+		complete = append(complete, m.flush(entry.Source)...)
+		exists = false
+	}
+
+	if exists {
+		event.entry.Line = append(append(event.entry.Line, '\n'), entry.Line...)
+		event.entry.Partial = entry.Partial
+		event.lines++
+		event.updated = now
+	} else {
+		// the timestamp of the first line is kept
+		event = &multilineEvent{entry: entry, lines: 1, updated: now}
+		event.entry.Line = append([]byte(nil), entry.Line...)
+		m.events[entry.Source] = event
+	}
+
+	if (m.match == multilineBefore && !continuation) || (m.maxLines > 0 && event.lines >= m.maxLines) {
+		complete = append(complete, m.flush(entry.Source)...)
+	}
+
+	return complete
+}
+
+// Expire returns the log entries, which have not been updated within the timeout
+func (m *multiline) Expire(now time.Time) []logdriver.LogEntry {
+	var complete []logdriver.LogEntry
+	for source, event := range m.events {
+		if now.Sub(event.updated) >= m.timeout {
+			complete = append(complete, m.flush(source)...)
+		}
+	}
+	return complete
+}
+
+// Flush returns all pending log entries
+func (m *multiline) Flush() []logdriver.LogEntry {
+	var complete []logdriver.LogEntry
+	for source := range m.events {
+		complete = append(complete, m.flush(source)...)
+	}
+	return complete
+}
+
+func (m *multiline) flush(source string) []logdriver.LogEntry {
+	event, exists := m.events[source]
+	if !exists {
+		return nil
+	}
+	delete(m.events, source)
+	return []logdriver.LogEntry{event.entry}
+}
@@ -0,0 +1,99 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+)
+
+func Test_multiline(t *testing.T) {
+	type args struct {
+		pattern  string
+		negate   bool
+		match    string
+		maxLines int
+	}
+	tests := []struct {
+		name  string
+		args  args
+		lines []string
+		want  []string
+	}{
+		{
+			name:  "java stack trace",
+			args:  args{pattern: `^\s`, match: multilineAfter},
+			lines: []string{"Exception in thread", "\tat com.example.Main", "\tat com.example.App", "next"},
+			want:  []string{"Exception in thread\n\tat com.example.Main\n\tat com.example.App", "next"},
+		},
+		{
+			name:  "negate",
+			args:  args{pattern: `^\[`, negate: true, match: multilineAfter},
+			lines: []string{"[INFO] first", "continued", "[INFO] second"},
+			want:  []string{"[INFO] first\ncontinued", "[INFO] second"},
+		},
+		{
+			name:  "before",
+			args:  args{pattern: `\\$`, match: multilineBefore},
+			lines: []string{`one \`, `two \`, "three", "four"},
+			want:  []string{"one \\\ntwo \\\nthree", "four"},
+		},
+		{
+			name:  "max lines",
+			args:  args{pattern: `^\s`, match: multilineAfter, maxLines: 2},
+			lines: []string{"panic", " 1", " 2", " 3"},
+			want:  []string{"panic\n 1", " 2\n 3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newMultiline(tt.args.pattern, tt.args.negate, tt.args.match, tt.args.maxLines, time.Second)
+			if err != nil {
+				t.Fatalf("newMultiline() error = %v", err)
+			}
+
+			var got []string
+			now := time.Now()
+			for i, line := range tt.lines {
+				entry := logdriver.LogEntry{Source: "stdout", Line: []byte(line), TimeNano: int64(i)}
+				for _, e := range m.Add(entry, now) {
+					got = append(got, string(e.Line))
+				}
+			}
+			for _, e := range m.Expire(now.Add(time.Second)) {
+				got = append(got, string(e.Line))
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("multiline = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_multilineTimestamp(t *testing.T) {
+	m, err := newMultiline(`^\s`, false, multilineAfter, 0, time.Second)
+	if err != nil {
+		t.Fatalf("newMultiline() error = %v", err)
+	}
+
+	now := time.Now()
+	m.Add(logdriver.LogEntry{Source: "stderr", Line: []byte("panic"), TimeNano: 1}, now)
+	m.Add(logdriver.LogEntry{Source: "stdout", Line: []byte("other"), TimeNano: 2}, now)
+	m.Add(logdriver.LogEntry{Source: "stderr", Line: []byte(" goroutine 1"), TimeNano: 3}, now)
+
+	if got := m.Expire(now.Add(time.Second / 2)); len(got) != 0 {
+		t.Errorf("Expire() = %v, want none", got)
+	}
+
+	got := m.Flush()
+	if len(got) != 2 {
+		t.Fatalf("Flush() = %v entries, want 2", len(got))
+	}
+	for _, e := range got {
+		if e.Source == "stderr" && (string(e.Line) != "panic\n goroutine 1" || e.TimeNano != 1) {
+			t.Errorf("Flush() = %q at %d, want %q at 1", e.Line, e.TimeNano, "panic\n goroutine 1")
+		}
+	}
+}
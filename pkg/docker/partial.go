@@ -0,0 +1,203 @@
+package docker
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/gogo/protobuf/proto"
+)
+
+// partialLogMetadataTag is the field number of the partial log metadata
+// in the log entries of newer docker daemons
+const partialLogMetadataTag = 5
+
+// PartialLogMetadata links the fragments of a log message,
+// which docker has split, because it exceeds 16KB
+type PartialLogMetadata struct {
+	Last    bool
+	ID      string
+	Ordinal int32
+}
+
+// logEntry is a log entry, which includes the partial log metadata
+// of newer docker daemons, when it is available
+type logEntry struct {
+	logdriver.LogEntry
+	PartialLogMetadata *PartialLogMetadata
+}
+
+// Reset implements proto.Message
+func (e *logEntry) Reset() {
+	*e = logEntry{}
+}
+
+// Unmarshal decodes the log entry and looks for the partial log metadata,
+// which is unknown to logdriver.LogEntry
+func (e *logEntry) Unmarshal(data []byte) error {
+	if err := e.LogEntry.Unmarshal(data); err != nil {
+		return err
+	}
+	return decodeFields(data, func(tag int, value []byte, varint uint64) error {
+		if tag != partialLogMetadataTag || value == nil {
+			return nil
+		}
+		metadata := &PartialLogMetadata{}
+		err := decodeFields(value, func(tag int, value []byte, varint uint64) error {
+			switch tag {
+			case 1:
+				metadata.Last = varint != 0
+			case 2:
+				metadata.ID = string(value)
+			case 3:
+				metadata.Ordinal = int32(varint)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		e.PartialLogMetadata = metadata
+		return nil
+	})
+}
+
+// decodeFields passes the length delimited value or the varint of each field to fn
+func decodeFields(data []byte, fn func(tag int, value []byte, varint uint64) error) error {
+	buf := proto.NewBuffer(data)
+	for {
+		key, err := buf.DecodeVarint()
+		if err != nil {
+			// no more fields
+			return nil
+		}
+
+		var value []byte
+		var varint uint64
+		switch key & 7 {
+		case proto.WireVarint:
+			varint, err = buf.DecodeVarint()
+		case proto.WireFixed64:
+			_, err = buf.DecodeFixed64()
+		case proto.WireBytes:
+			value, err = buf.DecodeRawBytes(false)
+		case proto.WireFixed32:
+			_, err = buf.DecodeFixed32()
+		default:
+			err = fmt.Errorf("unsupported wire type: %d", key&7)
+		}
+		if err != nil {
+			return fmt.Errorf("error: decoding log entry: %q", err)
+		}
+
+		if err := fn(int(key>>3), value, varint); err != nil {
+			return err
+		}
+	}
+}
+
+// partial reassembles the fragments of log messages, which docker splits
+// into several log entries. Fragments are identified by the partial log
+// metadata, if available, otherwise by the source, i.e. stdout or stderr.
+type partial struct {
+	maxSize int
+	timeout time.Duration
+
+	messages map[string]*partialMessage
+}
+
+type partialMessage struct {
+	fragments []logEntry
+	size      int
+	updated   time.Time
+}
+
+func newPartial(maxSize int, timeout time.Duration) *partial {
+	return &partial{
+		maxSize:  maxSize,
+		timeout:  timeout,
+		messages: make(map[string]*partialMessage),
+	}
+}
+
+// Add buffers a fragment and returns the log entries, which are complete
+func (p *partial) Add(entry logEntry, now time.Time) []logEntry {
+
+	var key string
+	var last bool
+	if entry.PartialLogMetadata != nil {
+		key = entry.PartialLogMetadata.ID
+		last = entry.PartialLogMetadata.Last
+	} else {
+		key = entry.Source
+		last = !entry.Partial
+	}
+
+	message, exists := p.messages[key]
+	if !exists {
+		// complete messages are passed through
+		if last {
+			return []logEntry{entry}
+		}
+		message = &partialMessage{}
+		p.messages[key] = message
+	}
+
+	message.fragments = append(message.fragments, entry)
+	message.size += len(entry.Line)
+	message.updated = now
+
+	switch {
+	case last:
+		return []logEntry{p.flush(key, true)}
+	case p.maxSize > 0 && message.size >= p.maxSize:
+		return []logEntry{p.flush(key, false)}
+	}
+	return nil
+}
+
+// Expire returns the incomplete log entries, which have not been updated within the timeout
+func (p *partial) Expire(now time.Time) []logEntry {
+	var entries []logEntry
+	for key, message := range p.messages {
+		if now.Sub(message.updated) >= p.timeout {
+			entries = append(entries, p.flush(key, false))
+		}
+	}
+	return entries
+}
+
+// Flush returns all incomplete log entries
+func (p *partial) Flush() []logEntry {
+	var entries []logEntry
+	for key := range p.messages {
+		entries = append(entries, p.flush(key, false))
+	}
+	return entries
+}
+
+// flush merges the fragments into the first one,
+// which is still partial, if the last fragment is missing
+func (p *partial) flush(key string, complete bool) logEntry {
+	message := p.messages[key]
+	delete(p.messages, key)
+
+	fragments := message.fragments
+	sort.SliceStable(fragments, func(i, j int) bool {
+		if fragments[i].PartialLogMetadata == nil || fragments[j].PartialLogMetadata == nil {
+			return false
+		}
+		return fragments[i].PartialLogMetadata.Ordinal < fragments[j].PartialLogMetadata.Ordinal
+	})
+
+	entry := fragments[0]
+	entry.Line = make([]byte, 0, message.size)
+	for _, fragment := range fragments {
+		entry.Line = append(entry.Line, fragment.Line...)
+	}
+	entry.Partial = !complete
+	entry.PartialLogMetadata = nil
+
+	return entry
+}
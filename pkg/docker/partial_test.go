@@ -0,0 +1,135 @@
+package docker
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/plugins/logdriver"
+	"github.com/gogo/protobuf/proto"
+)
+
+func Test_logEntryUnmarshal(t *testing.T) {
+	entry := logdriver.LogEntry{Source: "stdout", TimeNano: 42, Line: []byte("fragment"), Partial: true}
+	data, err := entry.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// partial_log_metadata as sent by newer docker daemons
+	metadata := proto.NewBuffer(nil)
+	metadata.EncodeVarint(1<<3 | proto.WireVarint)
+	metadata.EncodeVarint(1)
+	metadata.EncodeVarint(2<<3 | proto.WireBytes)
+	metadata.EncodeStringBytes("abc")
+	metadata.EncodeVarint(3<<3 | proto.WireVarint)
+	metadata.EncodeVarint(7)
+
+	buf := proto.NewBuffer(data)
+	buf.EncodeVarint(partialLogMetadataTag<<3 | proto.WireBytes)
+	buf.EncodeRawBytes(metadata.Bytes())
+
+	var got logEntry
+	if err := proto.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if string(got.Line) != "fragment" || got.TimeNano != 42 || !got.Partial {
+		t.Errorf("Unmarshal() = %v, want %v", got.LogEntry, entry)
+	}
+	want := &PartialLogMetadata{Last: true, ID: "abc", Ordinal: 7}
+	if !reflect.DeepEqual(got.PartialLogMetadata, want) {
+		t.Errorf("Unmarshal() metadata = %v, want %v", got.PartialLogMetadata, want)
+	}
+
+	got.Reset()
+	if err := proto.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if got.PartialLogMetadata != nil {
+		t.Errorf("Unmarshal() metadata = %v, want nil", got.PartialLogMetadata)
+	}
+}
+
+func fragment(source, line string, partial bool, metadata *PartialLogMetadata) logEntry {
+	return logEntry{
+		LogEntry:           logdriver.LogEntry{Source: source, Line: []byte(line), Partial: partial},
+		PartialLogMetadata: metadata,
+	}
+}
+
+func Test_partial(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxSize   int
+		fragments []logEntry
+		want      []string
+		partial   []bool
+	}{
+		{
+			name:      "complete line",
+			fragments: []logEntry{fragment("stdout", "line", false, nil)},
+			want:      []string{"line"},
+			partial:   []bool{false},
+		},
+		{
+			name: "partial flag",
+			fragments: []logEntry{
+				fragment("stdout", "a", true, nil),
+				fragment("stderr", "x", false, nil),
+				fragment("stdout", "b", true, nil),
+				fragment("stdout", "c", false, nil),
+			},
+			want:    []string{"x", "abc"},
+			partial: []bool{false, false},
+		},
+		{
+			name: "partial log metadata",
+			fragments: []logEntry{
+				fragment("stdout", "b", true, &PartialLogMetadata{ID: "1", Ordinal: 2}),
+				fragment("stdout", "a", true, &PartialLogMetadata{ID: "1", Ordinal: 1}),
+				fragment("stdout", "c", true, &PartialLogMetadata{ID: "1", Ordinal: 3, Last: true}),
+			},
+			want:    []string{"abc"},
+			partial: []bool{false},
+		},
+		{
+			name:    "max size",
+			maxSize: 2,
+			fragments: []logEntry{
+				fragment("stdout", "a", true, nil),
+				fragment("stdout", "b", true, nil),
+				fragment("stdout", "c", false, nil),
+			},
+			want:    []string{"ab", "c"},
+			partial: []bool{true, false},
+		},
+		{
+			name:      "timeout",
+			fragments: []logEntry{fragment("stdout", "a", true, nil)},
+			want:      []string{"a"},
+			partial:   []bool{true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newPartial(tt.maxSize, time.Second)
+
+			var got []logEntry
+			now := time.Now()
+			for _, f := range tt.fragments {
+				got = append(got, p.Add(f, now)...)
+			}
+			got = append(got, p.Expire(now.Add(time.Second))...)
+
+			var lines []string
+			var partial []bool
+			for _, e := range got {
+				lines = append(lines, string(e.Line))
+				partial = append(partial, e.Partial)
+			}
+			if !reflect.DeepEqual(lines, tt.want) || !reflect.DeepEqual(partial, tt.partial) {
+				t.Errorf("partial = %q %v, want %q %v", lines, partial, tt.want, tt.partial)
+			}
+		})
+	}
+}
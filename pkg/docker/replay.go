@@ -0,0 +1,165 @@
+package docker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/spool"
+)
+
+// replayer resends the spooled bulk requests of a container directory.
+// It outlives the container, so that the requests of a removed container
+// are still delivered, and deletes the directory once it is empty.
+type replayer struct {
+	dir    string
+	spool  *spool.Spool
+	logger *log.Entry
+	// newClient creates the client, once elasticsearch is available
+	newClient func() (elasticsearch.Client, error)
+	client    elasticsearch.Client
+	// deadLetter is the index of the documents rejected permanently
+	deadLetter string
+	// containers is the number of containers writing to the spool,
+	// it is guarded by the driver
+	containers int
+}
+
+// openSpool returns the spool of a container, which is shared with the
+// replayer of its directory. The first time a spool-dir is used, the
+// directories of other containers, e.g. which have been removed while
+// the plugin was not running, are replayed and deleted as well.
+func (d *Driver) openSpool(config Configuration, containerID string, newClient func() (elasticsearch.Client, error)) (*spool.Spool, error) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.spoolDirs[config.spoolDir] {
+		d.spoolDirs[config.spoolDir] = true
+
+		files, err := ioutil.ReadDir(config.spoolDir)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, f := range files {
+			if !f.IsDir() || f.Name() == containerID {
+				continue
+			}
+			if _, err := d.startReplay(config, f.Name(), newClient); err != nil {
+				log.WithField("spool", path.Join(config.spoolDir, f.Name())).WithError(err).Error("could not open spool")
+			}
+		}
+	}
+
+	r, err := d.startReplay(config, containerID, newClient)
+	if err != nil {
+		return nil, err
+	}
+	r.containers++
+
+	return r.spool, nil
+}
+
+// closeSpool releases the spool of a container, which stopped logging.
+// The requests are still replayed.
+func (d *Driver) closeSpool(s *spool.Spool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, r := range d.replayers {
+		if r.spool == s {
+			r.containers--
+		}
+	}
+}
+
+// startReplay returns the replayer of a container directory and starts it,
+// if it is not running yet. The lock must be held.
+func (d *Driver) startReplay(config Configuration, containerID string, newClient func() (elasticsearch.Client, error)) (*replayer, error) {
+
+	dir := path.Join(config.spoolDir, containerID)
+	if r, exists := d.replayers[dir]; exists {
+		return r, nil
+	}
+
+	s, err := spool.New(dir, config.spoolMaxSize, config.spoolDiscard)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &replayer{
+		dir:        dir,
+		spool:      s,
+		logger:     log.WithField("spool", dir),
+		newClient:  newClient,
+		deadLetter: config.deadLetterIndex,
+	}
+	d.replayers[dir] = r
+
+	r.logger.Debug("starting spool replay")
+	go d.replay(r, replayInterval)
+
+	return r, nil
+}
+
+// replay resends the spooled bulk requests periodically. Once no container
+// writes to the spool and all requests have been delivered, the directory
+// is deleted.
+func (d *Driver) replay(r *replayer, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if r.spool.Size() > 0 {
+			if err := r.resend(); err != nil {
+				r.logger.WithError(err).Debug("could not replay spool")
+				continue
+			}
+		}
+
+		d.mu.Lock()
+		if r.containers > 0 || r.spool.Size() > 0 {
+			d.mu.Unlock()
+			continue
+		}
+		// the directory is removed with the lock held,
+		// otherwise a new container might write to it
+		delete(d.replayers, r.dir)
+		if err := r.spool.Remove(); err != nil {
+			r.logger.WithError(err).Error("could not remove spool")
+		}
+		d.mu.Unlock()
+
+		if r.client != nil {
+			r.client.Stop()
+		}
+		r.logger.Debug("stopped spool replay")
+		return
+	}
+}
+
+// resend replays the spool, the client is created on first use. A segment
+// is kept, until no document has been rejected temporarily anymore.
+func (r *replayer) resend() error {
+	if r.client == nil {
+		client, err := r.newClient()
+		if err != nil {
+			return err
+		}
+		r.client = client
+	}
+
+	return r.spool.Replay(func(lines []string) error {
+		dropped, err := r.client.Replay(context.Background(), lines, r.deadLetter)
+		if dropped > 0 {
+			r.logger.WithField("documents", dropped).Error("dropped documents of the spool, which have been rejected")
+		}
+		return err
+	})
+}
@@ -0,0 +1,103 @@
+package docker
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/spool"
+)
+
+// replayClient records the replayed lines, the other methods are not used
+type replayClient struct {
+	elasticsearch.Client
+	mu    sync.Mutex
+	lines []string
+}
+
+func (c *replayClient) Replay(ctx context.Context, lines []string, deadLetter string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lines = append(c.lines, lines...)
+	return 0, nil
+}
+
+func (c *replayClient) Stop() {}
+
+func Test_replay(t *testing.T) {
+	root, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	config := newConfiguration()
+	config.spoolDir = root
+
+	// requests of a container, which has been removed while the plugin was stopped
+	removed, err := spool.New(path.Join(root, "removed"), config.spoolMaxSize, config.spoolDiscard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := []string{`{"index":{"_id":"1","_index":"docker"}}`, `{"message":"first"}`}
+	if err := removed.Write(lines); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &replayClient{}
+	d := NewDriver()
+	s, err := d.openSpool(config, "abc", func() (elasticsearch.Client, error) { return client, nil })
+	if err != nil {
+		t.Fatalf("openSpool() error = %v", err)
+	}
+
+	d.mu.Lock()
+	r, other := d.replayers[path.Join(root, "abc")], d.replayers[path.Join(root, "removed")]
+	d.mu.Unlock()
+	if r == nil || other == nil {
+		t.Fatalf("replayers = %v, want abc and removed", d.replayers)
+	}
+
+	// the spool of the removed container is replayed and deleted
+	d.replay(other, 10*time.Millisecond)
+	if !reflect.DeepEqual(client.lines, lines) {
+		t.Errorf("Replay() = %v, want %v", client.lines, lines)
+	}
+	if _, err := os.Stat(other.dir); !os.IsNotExist(err) {
+		t.Errorf("Stat() error = %v, want the directory removed", err)
+	}
+
+	// the spool is kept, while the container is logging
+	done := make(chan struct{})
+	go func() {
+		d.replay(r, 10*time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("replay() returned, while the container is logging")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	d.closeSpool(s)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("replay() did not return, once the container stopped logging")
+	}
+	if _, err := os.Stat(r.dir); !os.IsNotExist(err) {
+		t.Errorf("Stat() error = %v, want the directory removed", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.replayers) != 0 {
+		t.Errorf("replayers = %v, want none", d.replayers)
+	}
+}
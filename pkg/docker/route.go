@@ -0,0 +1,103 @@
+package docker
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+// routeRule sends the log messages, whose field matches the value, to
+// another index, e.g. grok.level==ERROR:errors-%Y.%m.%d
+type routeRule struct {
+	field  string
+	value  string
+	negate bool
+	index  string
+}
+
+// route is a routing rule of a container, whose index template has been evaluated
+type route struct {
+	routeRule
+	indexNames *indexNames
+}
+
+// parseRoutes parses the rules separated by a semicolon. The index follows
+// the last colon, because colons are not allowed in index names.
+func parseRoutes(opt string) ([]routeRule, error) {
+	var rules []routeRule
+	for _, rule := range strings.Split(opt, ";") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+
+		i := strings.LastIndex(rule, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("error: parsing elasticsearch-route: missing index: %s", rule)
+		}
+		condition, index := rule[:i], strings.TrimSpace(rule[i+1:])
+		if index == "" {
+			return nil, fmt.Errorf("error: parsing elasticsearch-route: missing index: %s", rule)
+		}
+
+		r := routeRule{index: index}
+		if j := strings.Index(condition, "!="); j >= 0 {
+			r.field, r.value, r.negate = condition[:j], condition[j+2:], true
+		} else if j := strings.Index(condition, "=="); j >= 0 {
+			r.field, r.value = condition[:j], condition[j+2:]
+		} else {
+			return nil, fmt.Errorf("error: parsing elasticsearch-route: missing == or != in condition: %s", condition)
+		}
+		r.field = strings.TrimSpace(r.field)
+
+		switch {
+		case r.field == "source", r.field == "containerID", r.field == "containerName", r.field == "containerImageName":
+		case strings.HasPrefix(r.field, "grok.") && len(r.field) > len("grok."):
+		case strings.HasPrefix(r.field, "containerLabels.") && len(r.field) > len("containerLabels."):
+		default:
+			return nil, fmt.Errorf("error: parsing elasticsearch-route: unknown field: %s", r.field)
+		}
+
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// newRoutes evaluates the index templates of the rules
+func newRoutes(opt string, info logger.Info) ([]route, error) {
+	rules, err := parseRoutes(opt)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make([]route, 0, len(rules))
+	for _, rule := range rules {
+		index, err := parseIndexTemplate(rule.index, info)
+		if err != nil {
+			return nil, err
+		}
+		routes = append(routes, route{routeRule: rule, indexNames: newIndexNames(index)})
+	}
+	return routes, nil
+}
+
+// Match reports whether the rule applies to the log message. The container
+// metadata is taken from info, because it is not part of all log messages.
+func (r routeRule) Match(msg LogMessage, info *logger.Info) bool {
+	var value string
+	switch {
+	case r.field == "source":
+		value = msg.Source
+	case r.field == "containerID":
+		value = info.ContainerID
+	case r.field == "containerName":
+		value = info.Name()
+	case r.field == "containerImageName":
+		value = info.ContainerImageName
+	case strings.HasPrefix(r.field, "grok."):
+		value = msg.GrokLine[strings.TrimPrefix(r.field, "grok.")]
+	case strings.HasPrefix(r.field, "containerLabels."):
+		value = info.ContainerLabels[strings.TrimPrefix(r.field, "containerLabels.")]
+	}
+	return (value == r.value) != r.negate
+}
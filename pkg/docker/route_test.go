@@ -0,0 +1,86 @@
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/daemon/logger"
+)
+
+func Test_parseRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		opt     string
+		want    []routeRule
+		wantErr bool
+	}{
+		{name: "empty", opt: ""},
+		{
+			name: "rules",
+			opt:  "grok.level==ERROR:errors-%Y.%m.%d;source!=stdout:stderr-%F;",
+			want: []routeRule{
+				{field: "grok.level", value: "ERROR", index: "errors-%Y.%m.%d"},
+				{field: "source", value: "stdout", negate: true, index: "stderr-%F"},
+			},
+		},
+		{name: "value with colon", opt: "grok.url==http://localhost:urls", want: []routeRule{{field: "grok.url", value: "http://localhost", index: "urls"}}},
+		{name: "missing index", opt: "source==stderr", wantErr: true},
+		{name: "missing operator", opt: "source:stderr", wantErr: true},
+		{name: "unknown field", opt: "level==ERROR:errors", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRoutes(tt.opt)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseRoutes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseRoutes() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("parseRoutes() = %v, want %v", got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_containerIndex(t *testing.T) {
+	info := logger.Info{ContainerName: "/web", ContainerLabels: map[string]string{"team": "payments"}}
+	routes, err := newRoutes(`grok.level==ERROR:errors-%Y;source==stderr:stderr-%Y;containerLabels.team==payments:{{.ContainerName}}-%Y`, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &container{
+		info:       info,
+		indexNames: newIndexNames("docker-%Y"),
+		routes:     routes,
+	}
+
+	tests := []struct {
+		name     string
+		source   string
+		grokLine map[string]string
+		labels   map[string]string
+		want     string
+	}{
+		{name: "grok field", source: "stdout", grokLine: map[string]string{"level": "ERROR"}, want: "errors-2018"},
+		{name: "first rule wins", source: "stderr", grokLine: map[string]string{"level": "ERROR"}, want: "errors-2018"},
+		{name: "source", source: "stderr", want: "stderr-2018"},
+		{name: "label", source: "stdout", labels: map[string]string{"team": "payments"}, want: "web-2018"},
+		{name: "default", source: "stdout", grokLine: map[string]string{"level": "INFO"}, want: "docker-2018"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c.info.ContainerLabels = tt.labels
+			doc := LogMessage{GrokLine: tt.grokLine}
+			doc.Source = tt.source
+			doc.TimeNano = time.Date(2018, 6, 1, 0, 0, 0, 0, time.Local).UnixNano()
+			if got := c.index(doc); got != tt.want {
+				t.Errorf("index() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,27 +2,56 @@ package elasticsearch
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/metrics"
+
 	elasticv1 "github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/v1"
 	elasticv2 "github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/v2"
 	elasticv5 "github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/v5"
 	elasticv6 "github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/v6"
+	elasticv7 "github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/v7"
+
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/rest"
 )
 
 // Client ...
 type Client interface {
-	Add(index, tzpe string, msg interface{}) error
+	// Add adds an index request to the bulk processor, the ingest
+	// pipeline is optional. The op type is either index or create,
+	// data streams only accept create. Elasticsearch assigns an id,
+	// if it is empty.
+	Add(index, tzpe, pipeline, opType, id string, msg interface{}) error
 
 	// NewBulkProcessorService(ctx context.Context, workers, bulkActions, bulkSize int, flushInterval time.Duration, stats bool) error
 	// Stop the bulk processor and do some cleanup
 	Close() error
 	Flush() error
 
-	NewBulkProcessorService(ctx context.Context, workers, actions, size int, flushInterval, timeout time.Duration, stats bool, log *logrus.Entry) error
+	// Documents rejected with a client error, which is not retried, are
+	// wrapped into the dead-letter index, if it is not empty
+	NewBulkProcessorService(ctx context.Context, workers, actions, size int, flushInterval, timeout time.Duration, retries int, deadLetter string, stats bool, spool func([]string) error, m *metrics.Container, log *logrus.Entry) error
+
+	// Replay sends the lines of spooled bulk requests to elasticsearch. It fails,
+	// if documents have been rejected temporarily. Documents rejected permanently
+	// are wrapped into the dead-letter index, if it is not empty, otherwise
+	// their number is returned.
+	Replay(ctx context.Context, lines []string, deadLetter string) (int, error)
+
+	// Read retrieves the log messages of a container in chronological order
+	// and passes the source and the sort values of each document to fn
+	Read(ctx context.Context, index, containerID string, since, until time.Time, tail int, fn func([]byte, []interface{}) error) error
+
+	// SearchAfter retrieves the log messages of a container written after the
+	// given sort values and passes the source and sort values of each document to fn
+	SearchAfter(ctx context.Context, index, containerID string, since, until time.Time, after []interface{}, fn func([]byte, []interface{}) error) error
+
+	// Stats returns the statistics of the bulk processor
+	Stats() metrics.BulkStats
 
 	// Stop stops the background processes that the client is running,
 	// i.e. sniffing the cluster periodically and running health checks
@@ -34,7 +63,7 @@ type Client interface {
 
 // Bulk Service implementation
 type Bulk interface {
-	Add(index, tzpe string, msg interface{})
+	Add(index, tzpe, pipeline, opType, id string, msg interface{})
 	CommitRequired(actions int, bulkSize int) bool
 	Do(ctx context.Context) (interface{}, int, bool, error)
 	Errors(bulkResponse interface{}) []map[int]string
@@ -42,29 +71,57 @@ type Bulk interface {
 	NumberOfActions() int
 }
 
-// NewClient ...
-func NewClient(version string, url, username, password string, timeout time.Duration, sniff bool, insecure bool) (Client, error) {
+// NewClient creates a client of the given version, auto detects
+// the version of the cluster. The oversize policy applies to documents,
+// which are too large for a bulk request.
+func NewClient(version string, url, username, password string, timeout time.Duration, sniff bool, tlsConfig *tls.Config, oversize string) (Client, error) {
+	if version == "auto" {
+		detected, err := DetectVersion(url, username, password, timeout, tlsConfig)
+		if err != nil {
+			return nil, err
+		}
+		version = detected
+	}
+
 	switch version {
 	case "1":
-		client, err := elasticv1.NewClient(url, username, password, timeout, sniff, insecure)
+		client, err := elasticv1.NewClient(url, username, password, timeout, sniff, tlsConfig, oversize)
 		if err != nil {
 			return nil, fmt.Errorf("error: cannot create an elasticsearch client: %v", err)
 		}
 		return client, nil
 	case "2":
-		client, err := elasticv2.NewClient(url, username, password, timeout, sniff, insecure)
+		client, err := elasticv2.NewClient(url, username, password, timeout, sniff, tlsConfig, oversize)
 		if err != nil {
 			return nil, fmt.Errorf("error: cannot create an elasticsearch client: %v", err)
 		}
 		return client, nil
 	case "5":
-		client, err := elasticv5.NewClient(url, username, password, timeout, sniff, insecure)
+		client, err := elasticv5.NewClient(url, username, password, timeout, sniff, tlsConfig, oversize)
 		if err != nil {
 			return nil, fmt.Errorf("error: cannot create an elasticsearch client: %v", err)
 		}
 		return client, nil
 	case "6":
-		client, err := elasticv6.NewClient(url, username, password, timeout, sniff, insecure)
+		client, err := elasticv6.NewClient(url, username, password, timeout, sniff, tlsConfig, oversize)
+		if err != nil {
+			return nil, fmt.Errorf("error: cannot create an elasticsearch client: %v", err)
+		}
+		return client, nil
+	case "7":
+		client, err := elasticv7.NewClient(url, username, password, timeout, sniff, tlsConfig, oversize)
+		if err != nil {
+			return nil, fmt.Errorf("error: cannot create an elasticsearch client: %v", err)
+		}
+		return client, nil
+	case "8":
+		client, err := rest.NewClient(url, username, password, timeout, tlsConfig, oversize, 8)
+		if err != nil {
+			return nil, fmt.Errorf("error: cannot create an elasticsearch client: %v", err)
+		}
+		return client, nil
+	case "opensearch":
+		client, err := rest.NewClient(url, username, password, timeout, tlsConfig, oversize, 0)
 		if err != nil {
 			return nil, fmt.Errorf("error: cannot create an elasticsearch client: %v", err)
 		}
@@ -76,6 +133,11 @@ func NewClient(version string, url, username, password string, timeout time.Dura
 
 // NewBulk returns a bulkService depending on the client version
 func NewBulk(client Client, timeout time.Duration, actions int) (Bulk, error) {
+	// the rest client serves several versions
+	if client, ok := client.(*rest.Elasticsearch); ok {
+		return rest.Bulk(client, timeout, actions), nil
+	}
+
 	version := client.Version()
 	switch version {
 	case 1:
@@ -86,6 +148,8 @@ func NewBulk(client Client, timeout time.Duration, actions int) (Bulk, error) {
 		return elasticv5.Bulk(client.(*elasticv5.Elasticsearch), timeout, actions), nil
 	case 6:
 		return elasticv6.Bulk(client.(*elasticv6.Elasticsearch), timeout, actions), nil
+	case 7:
+		return elasticv7.Bulk(client.(*elasticv7.Elasticsearch), timeout, actions), nil
 	default:
 		return nil, fmt.Errorf("error: elasticsearch version not supported: %v", version)
 	}
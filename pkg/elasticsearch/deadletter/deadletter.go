@@ -0,0 +1,78 @@
+// Package deadletter wraps the documents, which have been rejected by
+// elasticsearch, e.g. because of mapping conflicts, so that they can be
+// indexed into a dead-letter index without losing them
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Document wraps a rejected document, which is kept as an unindexed string
+type Document struct {
+	Timestamp time.Time `json:"@timestamp"`
+	Index     string    `json:"index"`
+	Status    int       `json:"status"`
+	Type      string    `json:"type,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	Document  string    `json:"document"`
+}
+
+// Item is the result of a document of a bulk request
+type Item struct {
+	Status int
+	Type   string
+	Reason string
+}
+
+// Rejected reports whether a failed document belongs to the dead-letter index.
+// Documents rejected temporarily are resent, existing documents have been
+// indexed already and documents, which are too large, are rejected again.
+func Rejected(status int) bool {
+	return status >= 400 && status <= 499 && status != 409 && status != 413 && status != 429
+}
+
+// Lines returns the bulk api lines, which create the wrappers of the rejected
+// documents in the dead-letter index. The items are given in the order of
+// the action and source lines of the bulk request.
+func Lines(index string, lines []string, items []Item) ([]string, error) {
+	var wrappers []string
+	for i, item := range items {
+		if !Rejected(item.Status) || 2*i+1 >= len(lines) {
+			continue
+		}
+
+		var action map[string]map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[2*i]), &action); err != nil {
+			return nil, fmt.Errorf("error: parsing bulk action: %q", err)
+		}
+
+		// the type is kept for elasticsearch 5 and 6
+		create := map[string]interface{}{"_index": index}
+		doc := Document{
+			Timestamp: time.Now(),
+			Status:    item.Status,
+			Type:      item.Type,
+			Reason:    item.Reason,
+			Document:  lines[2*i+1],
+		}
+		for _, meta := range action {
+			if tzpe, ok := meta["_type"].(string); ok && tzpe != "" {
+				create["_type"] = tzpe
+			}
+			doc.Index, _ = meta["_index"].(string)
+		}
+
+		a, err := json.Marshal(map[string]interface{}{"create": create})
+		if err != nil {
+			return nil, err
+		}
+		source, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+		wrappers = append(wrappers, string(a), string(source))
+	}
+	return wrappers, nil
+}
@@ -0,0 +1,82 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	lines := []string{
+		`{"index":{"_index":"docker-2020.01.01","_type":"log","_id":"1"}}`,
+		`{"message":"hello","level":"info"}`,
+		`{"create":{"_index":"logs-docker","_id":"2"}}`,
+		`{"message":"hello","level":{"name":"info"}}`,
+	}
+
+	tests := []struct {
+		name     string
+		items    []Item
+		actions  []string
+		indices  []string
+		statuses []int
+	}{
+		{
+			name:  "succeeded",
+			items: []Item{{Status: 201}, {Status: 201}},
+		},
+		{
+			name:  "retryable",
+			items: []Item{{Status: 429}, {Status: 409}},
+		},
+		{
+			name:     "mapping",
+			items:    []Item{{Status: 201}, {Status: 400, Type: "mapper_parsing_exception", Reason: "failed to parse field [level]"}},
+			actions:  []string{`{"create":{"_index":"dead-letter"}}`},
+			indices:  []string{"logs-docker"},
+			statuses: []int{400},
+		},
+		{
+			name:     "type",
+			items:    []Item{{Status: 400}, {Status: 404}},
+			actions:  []string{`{"create":{"_index":"dead-letter","_type":"log"}}`, `{"create":{"_index":"dead-letter"}}`},
+			indices:  []string{"docker-2020.01.01", "logs-docker"},
+			statuses: []int{400, 404},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Lines("dead-letter", lines, tt.items)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 2*len(tt.actions) {
+				t.Fatalf("Lines() = %v, want %d lines", got, 2*len(tt.actions))
+			}
+			for i := range tt.actions {
+				if got[2*i] != tt.actions[i] {
+					t.Errorf("action = %v, want %v", got[2*i], tt.actions[i])
+				}
+				var doc Document
+				if err := json.Unmarshal([]byte(got[2*i+1]), &doc); err != nil {
+					t.Fatal(err)
+				}
+				if doc.Index != tt.indices[i] || doc.Status != tt.statuses[i] {
+					t.Errorf("document = %+v, want index %v and status %v", doc, tt.indices[i], tt.statuses[i])
+				}
+				var original map[string]interface{}
+				if err := json.Unmarshal([]byte(doc.Document), &original); err != nil {
+					t.Errorf("document is not the original json: %v", err)
+				}
+			}
+			if tt.name == "mapping" {
+				var doc Document
+				json.Unmarshal([]byte(got[1]), &doc)
+				want := Document{Timestamp: doc.Timestamp, Index: "logs-docker", Status: 400, Type: "mapper_parsing_exception", Reason: "failed to parse field [level]", Document: lines[3]}
+				if !reflect.DeepEqual(doc, want) {
+					t.Errorf("document = %+v, want %+v", doc, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+// Package resend resends the items of bulk requests, which have been
+// rejected temporarily by elasticsearch
+package resend
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Retryable reports whether a rejected item may succeed later, i.e. on
+// 429 Too Many Requests or 503 Service Unavailable. Other failures, like
+// 400 mapping errors, fail again.
+func Retryable(status int) bool {
+	return status == 429 || status == 503
+}
+
+// Items resends the action and source lines of the retryable items, whose
+// statuses are given in the order of the lines. It waits a jittered
+// exponential backoff between the attempts, which does not exceed max.
+// send returns the statuses of the lines it has sent. The lines of the
+// items, which are still rejected after all attempts, are returned.
+func Items(lines []string, statuses []int, attempts int, wait, max time.Duration, send func([]string) ([]int, error)) ([]string, error) {
+
+	rejected := filter(lines, statuses)
+	for attempt := 0; attempt < attempts && len(rejected) > 0; attempt++ {
+
+		time.Sleep(jitter(wait))
+		if wait *= 2; wait > max {
+			wait = max
+		}
+
+		statuses, err := send(rejected)
+		if err != nil {
+			return rejected, err
+		}
+		rejected = filter(rejected, statuses)
+	}
+	return rejected, nil
+}
+
+// filter returns the lines of the retryable items
+func filter(lines []string, statuses []int) []string {
+	var rejected []string
+	for i, status := range statuses {
+		if Retryable(status) && 2*i+1 < len(lines) {
+			rejected = append(rejected, lines[2*i], lines[2*i+1])
+		}
+	}
+	return rejected
+}
+
+// jitter returns a random duration between half and the full wait,
+// so that the workers do not retry at the same time
+func jitter(wait time.Duration) time.Duration {
+	if wait <= 1 {
+		return wait
+	}
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(half)))
+}
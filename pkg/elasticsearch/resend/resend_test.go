@@ -0,0 +1,49 @@
+package resend
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestItems(t *testing.T) {
+	lines := []string{"a1", "s1", "a2", "s2", "a3", "s3"}
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int
+		replies  [][]int
+		err      error
+		want     []string
+		sent     int
+	}{
+		{name: "accepted", statuses: []int{201, 201, 201}, attempts: 3, want: nil, sent: 0},
+		{name: "mapping error", statuses: []int{201, 400, 201}, attempts: 3, want: nil, sent: 0},
+		{name: "resent", statuses: []int{429, 201, 503}, attempts: 3, replies: [][]int{{201, 429}, {201}}, want: nil, sent: 2},
+		{name: "attempts", statuses: []int{429, 201, 201}, attempts: 2, replies: [][]int{{429}, {429}}, want: []string{"a1", "s1"}, sent: 2},
+		{name: "disabled", statuses: []int{429, 201, 201}, attempts: 0, want: []string{"a1", "s1"}, sent: 0},
+		{name: "error", statuses: []int{201, 201, 429}, attempts: 3, err: errors.New("connection refused"), want: []string{"a3", "s3"}, sent: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent int
+			got, err := Items(lines, tt.statuses, tt.attempts, time.Millisecond, time.Millisecond, func(lines []string) ([]int, error) {
+				sent++
+				if tt.err != nil {
+					return nil, tt.err
+				}
+				return tt.replies[sent-1], nil
+			})
+			if err != tt.err {
+				t.Errorf("Items() error = %v, want %v", err, tt.err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Items() = %v, want %v", got, tt.want)
+			}
+			if sent != tt.sent {
+				t.Errorf("Items() sent = %v, want %v", sent, tt.sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/resend"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/metrics"
+)
+
+// BulkResponse is the response of the bulk api
+type BulkResponse struct {
+	Took   int                            `json:"took"`
+	Errors bool                           `json:"errors"`
+	Items  []map[string]*BulkResponseItem `json:"items"`
+}
+
+// BulkResponseItem is the result of a single bulk request
+type BulkResponseItem struct {
+	Index  string        `json:"_index"`
+	ID     string        `json:"_id"`
+	Status int           `json:"status"`
+	Error  *ErrorDetails `json:"error,omitempty"`
+}
+
+// ErrorDetails describes the reason of a failed bulk request
+type ErrorDetails struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// Failed returns the items, which have not been indexed
+func (r *BulkResponse) Failed() []*BulkResponseItem {
+	var failed []*BulkResponseItem
+	for _, item := range r.Items {
+		for _, result := range item {
+			if result.Status < 200 || result.Status > 299 {
+				failed = append(failed, result)
+			}
+		}
+	}
+	return failed
+}
+
+// results returns the items in the order of the requests
+func (r *BulkResponse) results() []*BulkResponseItem {
+	results := make([]*BulkResponseItem, 0, len(r.Items))
+	for _, item := range r.Items {
+		for _, result := range item {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// statuses returns the statuses of the items in the order of the requests
+func (r *BulkResponse) statuses() []int {
+	statuses := make([]int, 0, len(r.Items))
+	for _, item := range r.Items {
+		for _, result := range item {
+			statuses = append(statuses, result.Status)
+		}
+	}
+	return statuses
+}
+
+type bulkAction struct {
+	Index  *bulkIndex `json:"index,omitempty"`
+	Create *bulkIndex `json:"create,omitempty"`
+}
+
+type bulkIndex struct {
+	Index    string `json:"_index"`
+	ID       string `json:"_id,omitempty"`
+	Pipeline string `json:"pipeline,omitempty"`
+}
+
+// request returns the metadata of the action, regardless of the op type
+func (a bulkAction) request() *bulkIndex {
+	if a.Create != nil {
+		return a.Create
+	}
+	return a.Index
+}
+
+// indexRequest returns the bulk api lines of a typeless index request,
+// the op type create is required by data streams
+func indexRequest(index, pipeline, opType, id string, msg interface{}) ([]string, error) {
+	source, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	request := &bulkIndex{Index: index, ID: id, Pipeline: pipeline}
+	var a bulkAction
+	if opType == "create" {
+		a.Create = request
+	} else {
+		a.Index = request
+	}
+	action, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(action), string(source)}, nil
+}
+
+// source returns the source of the i-th request of the bulk api lines,
+// the items of a bulk response are returned in the order of the requests
+func source(lines []string, i int) string {
+	if i < 0 || 2*i+1 >= len(lines) {
+		return "request not found"
+	}
+	return lines[2*i+1]
+}
+
+// retry calls fn with an exponential backoff, until it succeeds,
+// the error cannot be retried or the next wait exceeds the timeout
+func retry(timeout time.Duration, m *metrics.Container, fn func() error) error {
+	wait := 200 * time.Millisecond
+	for {
+		err := fn()
+		if err == nil || !retryable(err) || wait > timeout {
+			return err
+		}
+		m.Retry()
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// retryable reports whether a request may succeed later, i.e. on
+// connection errors, rejections and unavailable nodes
+func retryable(err error) bool {
+	if e, ok := err.(*Error); ok {
+		return e.Status == 429 || e.Status >= 500
+	}
+	return true
+}
+
+// BulkService ...
+type BulkService struct {
+	client  *Elasticsearch
+	lines   []string
+	size    int64
+	retries int
+	timeout time.Duration
+}
+
+// Bulk creates a service
+func Bulk(client *Elasticsearch, timeout time.Duration, actions int) *BulkService {
+	return &BulkService{
+		client:  client,
+		lines:   make([]string, 0, 2*actions),
+		retries: 3, // the default of elasticsearch-bulk-retries
+		timeout: timeout,
+	}
+}
+
+// Add adds an index request, mapping types have been removed,
+// therefore tzpe is ignored
+func (e *BulkService) Add(index, tzpe, pipeline, opType, id string, msg interface{}) {
+	lines, err := indexRequest(index, pipeline, opType, id, msg)
+	if err != nil {
+		return
+	}
+	e.lines = append(e.lines, lines...)
+	e.size += int64(len(lines[0]) + len(lines[1]) + 2)
+}
+
+// CommitRequired returns true if the service has to commit its
+// bulk requests, because of the number of actions or the size
+func (e *BulkService) CommitRequired(actions int, bulkSize int) bool {
+	if actions >= 0 && e.NumberOfActions() >= actions {
+		return true
+	}
+	if bulkSize >= 0 && e.size >= int64(bulkSize) {
+		return true
+	}
+	return false
+}
+
+// Do sends the batched requests to elasticsearch, the requests
+// are cleared on success
+func (e *BulkService) Do(ctx context.Context) (interface{}, int, bool, error) {
+	var response *BulkResponse
+	err := retry(e.timeout, nil, func() error {
+		var err error
+		response, err = e.client.bulk(ctx, e.lines)
+		return err
+	})
+	if err != nil {
+		return nil, 0, true, err
+	}
+
+	// the items rejected with 429 or 503 are resent with a backoff
+	rejected, err := resend.Items(e.lines, response.statuses(), e.retries, 200*time.Millisecond, e.timeout, func(lines []string) ([]int, error) {
+		response, err := e.client.bulk(ctx, lines)
+		if err != nil {
+			return nil, err
+		}
+		return response.statuses(), nil
+	})
+	e.lines = e.lines[:0]
+	e.size = 0
+	if len(rejected) > 0 {
+		if err == nil {
+			err = fmt.Errorf("error: %d requests are still rejected", len(rejected)/2)
+		}
+		return response, response.Took, true, err
+	}
+
+	return response, response.Took, response.Errors, nil
+}
+
+// Errors parses a BulkResponse and returns the reason of the failure requests
+func (e *BulkService) Errors(bulkResponse interface{}) []map[int]string {
+	response, ok := bulkResponse.(*BulkResponse)
+	if !ok || response == nil {
+		return nil
+	}
+
+	var reason []map[int]string
+	for _, result := range response.Failed() {
+		if result.Error == nil {
+			continue
+		}
+		reason = append(reason, map[int]string{
+			result.Status: result.Error.Reason,
+		})
+	}
+	return reason
+}
+
+// EstimatedSizeInBytes returns the size of all requests added via Add
+func (e *BulkService) EstimatedSizeInBytes() int64 {
+	return e.size
+}
+
+// NumberOfActions returns the number of requests that need to
+// be sent to elasticsearch on the next batch
+func (e *BulkService) NumberOfActions() int {
+	return len(e.lines) / 2
+}
@@ -0,0 +1,342 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/deadletter"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/resend"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/split"
+)
+
+// opensearchVersion is the api version of opensearch, which
+// has been forked from elasticsearch 7.10
+const opensearchVersion = 7
+
+// readSize is the number of documents fetched per request while reading logs
+const readSize = 1000
+
+// tiebreaker sorts documents with the same timestamp. Sorting by _id
+// is disabled by default since elasticsearch 8 and the index order of
+// _doc differs between shards, therefore the sequence number written
+// by the plugin is used.
+const tiebreaker = "sequence"
+
+// Elasticsearch is a client, which speaks the plain REST api of
+// elasticsearch 8 and opensearch, i.e. without mapping types
+type Elasticsearch struct {
+	client   *http.Client
+	url      string
+	username string
+	password string
+
+	// compatibleWith is the major version sent in the compatibility
+	// headers of elasticsearch, zero omits the headers
+	compatibleWith int
+	timeout        time.Duration
+
+	processor *bulkProcessor
+}
+
+// Error is returned, when elasticsearch responds with an unexpected status code
+type Error struct {
+	Status int
+	Body   string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("elasticsearch: unexpected status code %d: %s", e.Status, e.Body)
+}
+
+// NewClient creates a client and checks, whether the endpoint is available.
+// compatibleWith sets the compatibility headers of elasticsearch, zero
+// disables them, e.g. for opensearch.
+func NewClient(address, username, password string, timeout time.Duration, tlsConfig *tls.Config, oversize string, compatibleWith int) (*Elasticsearch, error) {
+
+	e := &Elasticsearch{
+		client: &http.Client{
+			// bulk requests, which are too large, are sent in halves
+			Transport: &split.Transport{
+				Base: &http.Transport{
+					Proxy:           http.ProxyFromEnvironment,
+					TLSClientConfig: tlsConfig,
+				},
+				Policy: oversize,
+			},
+			Timeout: timeout,
+		},
+		url:            strings.TrimRight(address, "/"),
+		username:       username,
+		password:       password,
+		compatibleWith: compatibleWith,
+		timeout:        timeout,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := e.perform(ctx, "GET", "/", "", nil); err != nil {
+		return nil, fmt.Errorf("elasticsearch: cannot connect to the endpoint: %s\n%v", address, err)
+	}
+
+	return e, nil
+}
+
+// perform sends a request and returns the body of a successful response
+func (e *Elasticsearch) perform(ctx context.Context, method, path, contentType string, body []byte) ([]byte, error) {
+
+	req, err := http.NewRequest(method, e.url+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	if e.username != "" || e.password != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	req.Header.Set("Accept", e.mediaType("json"))
+	if contentType != "" {
+		req.Header.Set("Content-Type", e.mediaType(contentType))
+	}
+
+	res, err := e.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &Error{Status: res.StatusCode, Body: string(data)}
+	}
+	return data, nil
+}
+
+// mediaType returns the media type of json or x-ndjson, including
+// the compatibility version of elasticsearch, if required
+func (e *Elasticsearch) mediaType(subtype string) string {
+	if e.compatibleWith == 0 {
+		return "application/" + subtype
+	}
+	return fmt.Sprintf("application/vnd.elasticsearch+%s; compatible-with=%d", subtype, e.compatibleWith)
+}
+
+// bulk sends the lines of bulk requests
+func (e *Elasticsearch) bulk(ctx context.Context, lines []string) (*BulkResponse, error) {
+	body := strings.Join(lines, "\n") + "\n"
+
+	data, err := e.perform(ctx, "POST", "/_bulk", "x-ndjson", []byte(body))
+	if err != nil {
+		return nil, err
+	}
+
+	response := new(BulkResponse)
+	if err := json.Unmarshal(data, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// Replay sends the lines of spooled bulk requests to elasticsearch. It fails,
+// if documents have been rejected temporarily, so that they are replayed again.
+// Documents, which have been rejected permanently, are indexed into the
+// dead-letter index, if it is not empty, otherwise their number is returned.
+func (e *Elasticsearch) Replay(ctx context.Context, lines []string, deadLetter string) (int, error) {
+	if len(lines) < 2 {
+		return 0, nil
+	}
+	response, err := e.bulk(ctx, lines)
+	if err != nil {
+		return 0, err
+	}
+	if !response.Errors {
+		return 0, nil
+	}
+
+	var dropped int
+	for _, status := range response.statuses() {
+		switch {
+		case status >= 200 && status <= 299 || status == 409:
+			// the document has been indexed, e.g. by a previous replay
+		case resend.Retryable(status):
+			return 0, fmt.Errorf("error: documents have been rejected temporarily with status %d", status)
+		case deadLetter == "" || !deadletter.Rejected(status):
+			dropped++
+		}
+	}
+
+	if deadLetter != "" {
+		if err := e.deadLetter(ctx, deadLetter, lines, response); err != nil {
+			return 0, err
+		}
+	}
+	return dropped, nil
+}
+
+// deadLetter indexes the documents, which have been rejected, e.g. because
+// of mapping conflicts, wrapped into the dead-letter index
+func (e *Elasticsearch) deadLetter(ctx context.Context, index string, lines []string, response *BulkResponse) error {
+	var items []deadletter.Item
+	for _, result := range response.results() {
+		i := deadletter.Item{Status: result.Status}
+		if result.Error != nil {
+			i.Type, i.Reason = result.Error.Type, result.Error.Reason
+		}
+		items = append(items, i)
+	}
+
+	wrappers, err := deadletter.Lines(index, lines, items)
+	if err != nil || len(wrappers) == 0 {
+		return err
+	}
+
+	response, err = e.bulk(ctx, wrappers)
+	if err != nil {
+		return err
+	}
+	if response.Errors {
+		return fmt.Errorf("error: %d documents have been rejected by the dead-letter index", len(response.Failed()))
+	}
+	return nil
+}
+
+// Read retrieves the log messages of a container in chronological order
+// and passes the source and the sort values of each document to fn
+func (e *Elasticsearch) Read(ctx context.Context, index, containerID string, since, until time.Time, tail int, fn func([]byte, []interface{}) error) error {
+
+	if tail <= 0 {
+		return e.SearchAfter(ctx, index, containerID, since, until, nil, fn)
+	}
+
+	// only the last messages are requested, look them up backwards
+	// and reverse them afterwards
+	result, err := e.search(ctx, index, map[string]interface{}{
+		"query": readQuery(containerID, since, until),
+		"sort":  readSort("desc"),
+		"size":  tail,
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(result.Hits.Hits) - 1; i >= 0; i-- {
+		if err := fn(result.Hits.Hits[i].Source, result.Hits.Hits[i].Sort); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchAfter retrieves the log messages of a container written after the
+// given sort values and passes the source and sort values of each document to fn
+func (e *Elasticsearch) SearchAfter(ctx context.Context, index, containerID string, since, until time.Time, after []interface{}, fn func([]byte, []interface{}) error) error {
+
+	query := readQuery(containerID, since, until)
+
+	for {
+		source := map[string]interface{}{
+			"query": query,
+			"sort":  readSort("asc"),
+			"size":  readSize,
+		}
+		if after != nil {
+			source["search_after"] = after
+		}
+
+		result, err := e.search(ctx, index, source)
+		if err != nil {
+			return err
+		}
+		for _, hit := range result.Hits.Hits {
+			if err := fn(hit.Source, hit.Sort); err != nil {
+				return err
+			}
+			after = hit.Sort
+		}
+		if len(result.Hits.Hits) < readSize {
+			return nil
+		}
+	}
+}
+
+type searchResult struct {
+	Hits struct {
+		Hits []searchHit `json:"hits"`
+	} `json:"hits"`
+}
+
+type searchHit struct {
+	Source json.RawMessage `json:"_source"`
+	Sort   []interface{}   `json:"sort"`
+}
+
+func (e *Elasticsearch) search(ctx context.Context, index string, source map[string]interface{}) (*searchResult, error) {
+	body, err := json.Marshal(source)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := e.perform(ctx, "POST", "/"+index+"/_search?ignore_unavailable=true", "json", body)
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers are kept as they are, because the sort values are sent back
+	result := new(searchResult)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// readQuery filters the log messages of a container by their timestamp
+func readQuery(containerID string, since, until time.Time) map[string]interface{} {
+	filter := []interface{}{
+		map[string]interface{}{"term": map[string]interface{}{"containerID": containerID}},
+	}
+	if !since.IsZero() || !until.IsZero() {
+		timestamp := make(map[string]interface{})
+		if !since.IsZero() {
+			timestamp["gte"] = since
+		}
+		if !until.IsZero() {
+			timestamp["lte"] = until
+		}
+		filter = append(filter, map[string]interface{}{"range": map[string]interface{}{"timestamp": timestamp}})
+	}
+	return map[string]interface{}{"bool": map[string]interface{}{"filter": filter}}
+}
+
+// readSort orders the log messages by timestamp and the tiebreaker
+func readSort(order string) []interface{} {
+	return []interface{}{
+		map[string]interface{}{"timestamp": map[string]interface{}{"order": order, "unmapped_type": "date"}},
+		map[string]interface{}{tiebreaker: map[string]interface{}{"order": order, "unmapped_type": "long"}},
+	}
+}
+
+// Stop closes the idle connections, there are no background processes
+func (e *Elasticsearch) Stop() {
+	if tr, ok := e.client.Transport.(interface{ CloseIdleConnections() }); ok {
+		tr.CloseIdleConnections()
+	}
+}
+
+// Version reports the api version, i.e. the compatibility version
+// of elasticsearch or the version opensearch has been forked from
+func (e *Elasticsearch) Version() int {
+	if e.compatibleWith == 0 {
+		return opensearchVersion
+	}
+	return e.compatibleWith
+}
@@ -0,0 +1,170 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+func TestElasticsearch_Add(t *testing.T) {
+	tests := []struct {
+		name           string
+		compatibleWith int
+		contentType    string
+	}{
+		{name: "elasticsearch 8", compatibleWith: 8, contentType: "application/vnd.elasticsearch+x-ndjson; compatible-with=8"},
+		{name: "opensearch", compatibleWith: 0, contentType: "application/x-ndjson"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var contentType string
+			var lines []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/_bulk" {
+					contentType = r.Header.Get("Content-Type")
+					body, _ := ioutil.ReadAll(r.Body)
+					lines = strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+					w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"_id":"1","status":201}}]}`))
+					return
+				}
+				w.Write([]byte(`{"version":{"number":"8.0.0"}}`))
+			}))
+			defer ts.Close()
+
+			e, err := NewClient(ts.URL, "", "", time.Second, nil, "drop", tt.compatibleWith)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := e.NewBulkProcessorService(context.Background(), 1, 100, -1, 0, time.Second, 3, "", true, nil, nil, logrus.NewEntry(logrus.New())); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.Add("docker", "log", "", "index", "1", map[string]string{"message": "hello"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := e.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if contentType != tt.contentType {
+				t.Errorf("Content-Type = %v, want %v", contentType, tt.contentType)
+			}
+			if len(lines) != 2 {
+				t.Fatalf("lines = %v, want an action and a source", lines)
+			}
+			var action map[string]map[string]string
+			if err := json.Unmarshal([]byte(lines[0]), &action); err != nil {
+				t.Fatal(err)
+			}
+			if _, exists := action["index"]["_type"]; exists || action["index"]["_index"] != "docker" {
+				t.Errorf("action = %v, want a typeless index request", lines[0])
+			}
+			if lines[1] != `{"message":"hello"}` {
+				t.Errorf("source = %v, want %v", lines[1], `{"message":"hello"}`)
+			}
+			if stats := e.Stats(); stats.Succeeded != 1 {
+				t.Errorf("Stats().Succeeded = %v, want 1", stats.Succeeded)
+			}
+		})
+	}
+}
+
+func TestElasticsearch_Read(t *testing.T) {
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/docker-*/_search" {
+			data, _ := ioutil.ReadAll(r.Body)
+			body = string(data)
+			w.Write([]byte(`{"hits":{"total":{"value":2,"relation":"eq"},"hits":[
+				{"_source":{"message":"second"},"sort":[2,1]},
+				{"_source":{"message":"first"},"sort":[1,0]}
+			]}}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	e, err := NewClient(ts.URL, "", "", time.Second, nil, "drop", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	err = e.Read(context.Background(), "docker-*", "abc", time.Time{}, time.Time{}, 2, func(source []byte, sort []interface{}) error {
+		messages = append(messages, string(source))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`{"message":"first"}`, `{"message":"second"}`}
+	if strings.Join(messages, ",") != strings.Join(want, ",") {
+		t.Errorf("Read() = %v, want %v", messages, want)
+	}
+	// the index order of _doc is not stable across shards
+	if !strings.Contains(body, `"sequence"`) {
+		t.Errorf("Read() sort = %v, want the sequence as tiebreaker", body)
+	}
+}
+
+func TestElasticsearch_Replay(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		deadLetter     string
+		wantDropped    int
+		wantDeadLetter bool
+		wantErr        bool
+	}{
+		{name: "indexed", status: 201},
+		{name: "existing", status: 409},
+		{name: "rejected temporarily", status: 429, wantErr: true},
+		{name: "mapping", status: 400, wantDropped: 1},
+		{name: "mapping with dead-letter", status: 400, deadLetter: "dead-letter", wantDeadLetter: true},
+		{name: "too large with dead-letter", status: 413, deadLetter: "dead-letter", wantDropped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deadLettered bool
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/_bulk" {
+					w.Write([]byte(`{"version":{"number":"7.10.0"}}`))
+					return
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				if strings.Contains(string(body), `"_index":"dead-letter"`) {
+					deadLettered = true
+					w.Write([]byte(`{"took":1,"errors":false,"items":[{"create":{"status":201}}]}`))
+					return
+				}
+				fmt.Fprintf(w, `{"took":1,"errors":%t,"items":[{"index":{"_index":"docker","_id":"1","status":%d,"error":{"type":"mapper_parsing_exception","reason":"failed"}}}]}`, tt.status > 299, tt.status)
+			}))
+			defer ts.Close()
+
+			e, err := NewClient(ts.URL, "", "", time.Second, nil, "drop", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := []string{`{"index":{"_index":"docker","_id":"1"}}`, `{"message":"hello"}`}
+			dropped, err := e.Replay(context.Background(), lines, tt.deadLetter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Replay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if dropped != tt.wantDropped {
+				t.Errorf("Replay() dropped = %v, want %v", dropped, tt.wantDropped)
+			}
+			if deadLettered != tt.wantDeadLetter {
+				t.Errorf("Replay() dead-letter = %v, want %v", deadLettered, tt.wantDeadLetter)
+			}
+		})
+	}
+}
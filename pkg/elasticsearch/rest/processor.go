@@ -0,0 +1,341 @@
+package rest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/resend"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/metrics"
+)
+
+// bulkProcessor batches index requests and commits them concurrently
+// by a number of workers, similar to the bulk processor of olivere
+type bulkProcessor struct {
+	client        *Elasticsearch
+	actions       int
+	size          int
+	flushInterval time.Duration
+	timeout       time.Duration
+	retries       int
+	deadLetter    string
+	stats         bool
+	spool         func([]string) error
+	metrics       *metrics.Container
+	log           *logrus.Entry
+
+	mu       sync.Mutex
+	idle     *sync.Cond
+	lines    []string
+	bytes    int
+	inflight int
+	closed   bool
+
+	requests chan []string
+	done     chan struct{}
+	workers  sync.WaitGroup
+
+	executionID int64
+	flushed     int64
+	committed   int64
+	indexed     int64
+	succeeded   int64
+	failed      int64
+}
+
+// NewBulkProcessorService starts the workers of the bulk processor
+func (e *Elasticsearch) NewBulkProcessorService(ctx context.Context, workers, actions, size int, flushInterval, timeout time.Duration, retries int, deadLetter string, stats bool, spool func([]string) error, m *metrics.Container, log *logrus.Entry) error {
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	p := &bulkProcessor{
+		client:        e,
+		actions:       actions,
+		size:          size,
+		flushInterval: flushInterval,
+		timeout:       timeout,
+		retries:       retries,
+		deadLetter:    deadLetter,
+		stats:         stats,
+		spool:         spool,
+		metrics:       m,
+		log:           log,
+		requests:      make(chan []string, workers),
+		done:          make(chan struct{}),
+	}
+	p.idle = sync.NewCond(&p.mu)
+
+	for i := 0; i < workers; i++ {
+		p.workers.Add(1)
+		go p.worker()
+	}
+
+	if flushInterval > 0 {
+		go p.flusher()
+	}
+
+	e.processor = p
+
+	return nil
+}
+
+// Add adds a typeless index request of the op type index or create
+// to the bulk processor, tzpe is ignored
+func (e *Elasticsearch) Add(index, tzpe, pipeline, opType, id string, msg interface{}) error {
+	lines, err := indexRequest(index, pipeline, opType, id, msg)
+	if err != nil {
+		return err
+	}
+	return e.processor.add(lines)
+}
+
+// Flush commits all pending requests and waits until they are sent
+func (e *Elasticsearch) Flush() error {
+	return e.processor.flush()
+}
+
+// Close flushes the pending requests and stops the workers
+func (e *Elasticsearch) Close() error {
+	return e.processor.close()
+}
+
+// Stats returns the statistics of the bulk processor
+func (e *Elasticsearch) Stats() metrics.BulkStats {
+	if e.processor == nil || !e.processor.stats {
+		return metrics.BulkStats{}
+	}
+
+	p := e.processor
+	p.mu.Lock()
+	queued := int64(len(p.lines) / 2)
+	p.mu.Unlock()
+
+	return metrics.BulkStats{
+		Flushed:   atomic.LoadInt64(&p.flushed),
+		Committed: atomic.LoadInt64(&p.committed),
+		Indexed:   atomic.LoadInt64(&p.indexed),
+		Succeeded: atomic.LoadInt64(&p.succeeded),
+		Failed:    atomic.LoadInt64(&p.failed),
+		Queued:    queued,
+	}
+}
+
+func (p *bulkProcessor) add(lines []string) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errors.New("elasticsearch: bulk processor is closed")
+	}
+	p.lines = append(p.lines, lines...)
+	p.bytes += len(lines[0]) + len(lines[1]) + 2
+	required := (p.actions >= 0 && len(p.lines)/2 >= p.actions) || (p.size >= 0 && p.bytes >= p.size)
+	p.mu.Unlock()
+
+	atomic.AddInt64(&p.indexed, 1)
+
+	if required {
+		p.commit()
+	}
+	return nil
+}
+
+// commit passes the pending requests to the workers
+func (p *bulkProcessor) commit() {
+	p.mu.Lock()
+	lines := p.lines
+	p.lines, p.bytes = nil, 0
+	if len(lines) > 0 {
+		p.inflight++
+	}
+	p.mu.Unlock()
+
+	if len(lines) > 0 {
+		p.requests <- lines
+	}
+}
+
+func (p *bulkProcessor) flush() error {
+	p.commit()
+
+	p.mu.Lock()
+	for p.inflight > 0 {
+		p.idle.Wait()
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+func (p *bulkProcessor) close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	close(p.done)
+	p.flush()
+	close(p.requests)
+	p.workers.Wait()
+
+	return nil
+}
+
+// flusher commits the pending requests every flush interval
+func (p *bulkProcessor) flusher() {
+	ticker := time.NewTicker(p.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			atomic.AddInt64(&p.flushed, 1)
+			p.commit()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *bulkProcessor) worker() {
+	defer p.workers.Done()
+
+	for lines := range p.requests {
+		p.send(lines)
+
+		p.mu.Lock()
+		p.inflight--
+		if p.inflight == 0 {
+			p.idle.Broadcast()
+		}
+		p.mu.Unlock()
+	}
+}
+
+// send commits the requests, on failure they are spooled
+func (p *bulkProcessor) send(lines []string) {
+
+	executionID := atomic.AddInt64(&p.executionID, 1)
+	p.metrics.BulkStarted(executionID)
+	atomic.AddInt64(&p.committed, 1)
+
+	var response *BulkResponse
+	err := retry(p.timeout, p.metrics, func() error {
+		var err error
+		response, err = p.client.bulk(context.Background(), lines)
+		return err
+	})
+
+	p.metrics.BulkFinished(executionID)
+
+	if err != nil {
+		atomic.AddInt64(&p.failed, int64(len(lines)/2))
+		p.log.WithError(err).WithFields(logrus.Fields{
+			"workerId": executionID,
+			"requests": len(lines) / 2,
+		}).Error("after func")
+
+		// keep the requests on disk, they are replayed once elasticsearch is available
+		if p.spool != nil {
+			if serr := p.spool(lines); serr != nil {
+				p.log.WithError(serr).Error("could not spool requests")
+				p.metrics.Dropped(len(lines) / 2)
+			}
+		}
+		return
+	}
+
+	statuses := p.count(response)
+
+	if response.Errors {
+		// find out the reasons of the failure
+		for i, result := range response.results() {
+			// rejected requests are logged, if they cannot be resent
+			if result.Error == nil || (p.retries > 0 && resend.Retryable(result.Status)) {
+				continue
+			}
+			p.log.WithFields(logrus.Fields{
+				"workerId":  executionID,
+				"requestId": result.ID,
+				"request":   source(lines, i),
+				"reason":    result.Error.Reason,
+				"status":    result.Status,
+			}).Error("response error message and status code")
+		}
+
+		p.deadLetterItems(executionID, lines, response)
+		p.resend(executionID, lines, statuses)
+	}
+}
+
+// deadLetterItems indexes the documents, which have been rejected, e.g.
+// because of mapping conflicts, wrapped into the dead-letter index
+func (p *bulkProcessor) deadLetterItems(executionID int64, lines []string, response *BulkResponse) {
+	if p.deadLetter == "" {
+		return
+	}
+
+	if err := p.client.deadLetter(context.Background(), p.deadLetter, lines, response); err != nil {
+		p.log.WithError(err).WithField("workerId", executionID).Error("could not index rejected requests into the dead-letter index")
+	}
+}
+
+// count counts the results of the items and returns their statuses. The
+// retryable failures are counted once they have been resent.
+func (p *bulkProcessor) count(response *BulkResponse) []int {
+	statuses := make([]int, 0, len(response.Items))
+	for _, item := range response.Items {
+		for _, result := range item {
+			p.metrics.Item(result.Status)
+			switch {
+			case result.Status >= 200 && result.Status <= 299:
+				atomic.AddInt64(&p.succeeded, 1)
+			case p.retries > 0 && resend.Retryable(result.Status):
+			default:
+				atomic.AddInt64(&p.failed, 1)
+			}
+			statuses = append(statuses, result.Status)
+		}
+	}
+	return statuses
+}
+
+// resend retries the items rejected with 429 or 503, the items, which
+// are still rejected, are spooled
+func (p *bulkProcessor) resend(executionID int64, lines []string, statuses []int) {
+	if p.retries <= 0 {
+		return
+	}
+
+	rejected, err := resend.Items(lines, statuses, p.retries, 200*time.Millisecond, p.timeout, func(lines []string) ([]int, error) {
+		p.metrics.Retry()
+		response, err := p.client.bulk(context.Background(), lines)
+		if err != nil {
+			return nil, err
+		}
+		return p.count(response), nil
+	})
+	if len(rejected) == 0 {
+		return
+	}
+	atomic.AddInt64(&p.failed, int64(len(rejected)/2))
+
+	p.log.WithError(err).WithFields(logrus.Fields{
+		"workerId": executionID,
+		"requests": len(rejected) / 2,
+	}).Error("could not resend rejected requests")
+
+	if p.spool != nil {
+		if serr := p.spool(rejected); serr != nil {
+			p.log.WithError(serr).Error("could not spool requests")
+			p.metrics.Dropped(len(rejected) / 2)
+		}
+	}
+}
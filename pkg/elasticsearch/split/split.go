@@ -0,0 +1,215 @@
+// Package split sends bulk requests, which are rejected as too large by
+// elasticsearch or a proxy in front of it, in smaller parts
+package split
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Policies of single documents, which are still too large
+const (
+	Drop     = "drop"
+	Truncate = "truncate"
+)
+
+// Transport splits bulk requests rejected with 413 in halves, until they are
+// accepted, and merges the responses of the halves. Single documents, which
+// are still too large, are dropped or their message is truncated by the
+// policy. Dropped documents are reported as failed items with status 413.
+type Transport struct {
+	Base   http.RoundTripper
+	Policy string
+	// ErrorString reports the errors of items as strings, like elasticsearch 1
+	ErrorString bool
+}
+
+// response is the part of a bulk response required to merge the halves
+type response struct {
+	Took   int               `json:"took"`
+	Errors bool              `json:"errors"`
+	Items  []json.RawMessage `json:"items"`
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "POST" || !strings.HasSuffix(req.URL.Path, "_bulk") || req.Body == nil {
+		return t.Base.RoundTrip(req)
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := t.send(req, body)
+	if err != nil || res.StatusCode != http.StatusRequestEntityTooLarge {
+		return res, err
+	}
+
+	// only actions followed by a source can be split
+	lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+	if len(lines)%2 != 0 {
+		return res, nil
+	}
+	res.Body.Close()
+
+	merged, err := t.split(req, lines)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         res.Proto,
+		ProtoMajor:    res.ProtoMajor,
+		ProtoMinor:    res.ProtoMinor,
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
+
+// CloseIdleConnections closes the idle connections of the base transport
+func (t *Transport) CloseIdleConnections() {
+	if tr, ok := t.Base.(interface{ CloseIdleConnections() }); ok {
+		tr.CloseIdleConnections()
+	}
+}
+
+// send sends a copy of the request with the given body
+func (t *Transport) send(req *http.Request, body []byte) (*http.Response, error) {
+	r := new(http.Request)
+	*r = *req
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return t.Base.RoundTrip(r)
+}
+
+// split sends the halves of the lines, a single document is
+// truncated or dropped
+func (t *Transport) split(req *http.Request, lines []string) (*response, error) {
+	if len(lines) > 2 {
+		half := len(lines) / 4 * 2
+		first, err := t.bulk(req, lines[:half])
+		if err != nil {
+			return nil, err
+		}
+		second, err := t.bulk(req, lines[half:])
+		if err != nil {
+			return nil, err
+		}
+		return &response{
+			Took:   first.Took + second.Took,
+			Errors: first.Errors || second.Errors,
+			Items:  append(first.Items, second.Items...),
+		}, nil
+	}
+
+	if t.Policy == Truncate {
+		if source, ok := truncate(lines[1]); ok {
+			return t.bulk(req, []string{lines[0], source})
+		}
+	}
+	return t.rejected(lines[0])
+}
+
+// bulk sends the lines and splits them again, if they are still too large
+func (t *Transport) bulk(req *http.Request, lines []string) (*response, error) {
+	res, err := t.send(req, []byte(strings.Join(lines, "\n")+"\n"))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusRequestEntityTooLarge {
+		return t.split(req, lines)
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("split: bulk request failed with status %d", res.StatusCode)
+	}
+
+	var r response
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// truncate cuts the message of the source in half, it fails if there
+// is no message left
+func truncate(source string) (string, bool) {
+	decoder := json.NewDecoder(strings.NewReader(source))
+	decoder.UseNumber()
+
+	var doc map[string]interface{}
+	if err := decoder.Decode(&doc); err != nil {
+		return "", false
+	}
+	message, ok := doc["message"].(string)
+	if !ok || message == "" {
+		return "", false
+	}
+
+	message = message[:len(message)/2]
+	for len(message) > 0 && !utf8.ValidString(message) {
+		message = message[:len(message)-1]
+	}
+	doc["message"] = message
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+// rejected returns a failed item for the action of a dropped document
+func (t *Transport) rejected(action string) (*response, error) {
+	var a map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(action), &a); err != nil {
+		return nil, err
+	}
+
+	item := make(map[string]map[string]interface{}, 1)
+	for opType, meta := range a {
+		reason := "document is too large for a bulk request and has been dropped"
+		result := map[string]interface{}{
+			"status": http.StatusRequestEntityTooLarge,
+			"error": map[string]interface{}{
+				"type":   "request_entity_too_large",
+				"reason": reason,
+			},
+		}
+		if t.ErrorString {
+			result["error"] = reason
+		}
+		for _, key := range []string{"_index", "_type", "_id"} {
+			if v, exists := meta[key]; exists {
+				result[key] = v
+			}
+		}
+		item[opType] = result
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+	return &response{Errors: true, Items: []json.RawMessage{data}}, nil
+}
@@ -0,0 +1,89 @@
+package split
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTransport_RoundTrip(t *testing.T) {
+	small := `{"message":"hello"}`
+	large := `{"message":"` + strings.Repeat("x", 200) + `"}`
+	action := `{"index":{"_index":"docker","_id":"%d"}}`
+
+	tests := []struct {
+		name     string
+		sources  []string
+		policy   string
+		requests int
+		statuses []int
+	}{
+		{name: "accepted", sources: []string{small, small}, policy: Drop, requests: 1, statuses: []int{201, 201}},
+		{name: "split", sources: []string{small, small, small, small, small}, policy: Drop, requests: 5, statuses: []int{201, 201, 201, 201, 201}},
+		{name: "drop", sources: []string{small, large}, policy: Drop, requests: 3, statuses: []int{201, 413}},
+		{name: "truncate", sources: []string{small, large}, policy: Truncate, requests: 5, statuses: []int{201, 201}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				body, _ := ioutil.ReadAll(r.Body)
+				// like a proxy with a small client_max_body_size
+				if len(body) > 150 {
+					w.WriteHeader(http.StatusRequestEntityTooLarge)
+					w.Write([]byte("<html>413 Request Entity Too Large</html>"))
+					return
+				}
+				lines := strings.Split(strings.TrimSuffix(string(body), "\n"), "\n")
+				var items []string
+				for i := 0; i < len(lines); i += 2 {
+					items = append(items, `{"index":{"status":201}}`)
+				}
+				fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+			}))
+			defer ts.Close()
+
+			var body bytes.Buffer
+			for i, source := range tt.sources {
+				fmt.Fprintf(&body, action+"\n%s\n", i, source)
+			}
+
+			client := &http.Client{Transport: &Transport{Base: http.DefaultTransport, Policy: tt.policy}}
+			res, err := client.Post(ts.URL+"/_bulk", "application/x-ndjson", &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("StatusCode = %v, want %v", res.StatusCode, http.StatusOK)
+			}
+			var r struct {
+				Items []map[string]struct {
+					Status int `json:"status"`
+				} `json:"items"`
+			}
+			if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+				t.Fatal(err)
+			}
+			var statuses []int
+			for _, item := range r.Items {
+				for _, result := range item {
+					statuses = append(statuses, result.Status)
+				}
+			}
+			if fmt.Sprint(statuses) != fmt.Sprint(tt.statuses) {
+				t.Errorf("statuses = %v, want %v", statuses, tt.statuses)
+			}
+			if requests != tt.requests {
+				t.Errorf("requests = %v, want %v", requests, tt.requests)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package elasticsearch
+
+import (
+	"crypto/tls"
+	"fmt"
+	"time"
+)
+
+// Template describes the index template of the log messages
+// and the optional ILM policy attached to it
+type Template struct {
+	Name     string
+	Patterns []string
+	// Type is the mapping type of elasticsearch 5 and 6
+	Type     string
+	Shards   int
+	Replicas int
+	// DataStream creates data streams of the matching names, it requires
+	// composable templates of elasticsearch 7.9 or above
+	DataStream bool
+	// DeadLetter maps the wrappers of rejected documents instead of the
+	// log messages
+	DeadLetter bool
+
+	// Policy is the name of the ILM policy, it is not installed if empty
+	Policy      string
+	RolloverAge string
+	DeleteAge   string
+}
+
+// InstallTemplate installs the ILM policy and the index template of the
+// log messages. The version must have been detected already, if it is auto.
+func InstallTemplate(version, url, username, password string, timeout time.Duration, tlsConfig *tls.Config, t Template) error {
+
+	if t.Policy != "" {
+		if _, err := request("PUT", url, "/_ilm/policy/"+t.Policy, username, password, timeout, tlsConfig, policyBody(t)); err != nil {
+			return fmt.Errorf("error: installing ilm policy %s: %v", t.Policy, err)
+		}
+	}
+
+	path, body, err := templateBody(version, t)
+	if err != nil {
+		return err
+	}
+	if _, err := request("PUT", url, path, username, password, timeout, tlsConfig, body); err != nil {
+		return fmt.Errorf("error: installing index template %s: %v", t.Name, err)
+	}
+	return nil
+}
+
+// templateBody returns the path and the body of the index template. Legacy
+// templates are used up to version 7, composable templates afterwards and
+// for data streams.
+func templateBody(version string, t Template) (string, map[string]interface{}, error) {
+
+	settings := map[string]interface{}{
+		"number_of_shards":   t.Shards,
+		"number_of_replicas": t.Replicas,
+	}
+	if t.Policy != "" {
+		settings["index.lifecycle.name"] = t.Policy
+	}
+
+	// overlapping composable templates must not have the same priority.
+	// The built-in logs-*-* template of elasticsearch has a priority of 100
+	// and the dead-letter index may match the patterns of the log messages.
+	order, priority := 0, 150
+	if t.DeadLetter {
+		order, priority = 1, 250
+	}
+
+	switch version {
+	case "5":
+		// only a single pattern is supported, the other indices
+		// would not be mapped
+		if len(t.Patterns) > 1 {
+			return "", nil, fmt.Errorf("error: index templates of elasticsearch version 5 support a single pattern: %v", t.Patterns)
+		}
+		return "/_template/" + t.Name, map[string]interface{}{
+			"template": t.Patterns[0],
+			"order":    order,
+			"settings": settings,
+			"mappings": map[string]interface{}{t.Type: t.mappings("date")},
+		}, nil
+	case "6":
+		return "/_template/" + t.Name, map[string]interface{}{
+			"index_patterns": t.Patterns,
+			"order":          order,
+			"settings":       settings,
+			"mappings":       map[string]interface{}{t.Type: t.mappings("date")},
+		}, nil
+	case "7":
+		// data streams require composable templates
+		if !t.DataStream {
+			return "/_template/" + t.Name, map[string]interface{}{
+				"index_patterns": t.Patterns,
+				"order":          order,
+				"settings":       settings,
+				"mappings":       t.mappings("date_nanos"),
+			}, nil
+		}
+		fallthrough
+	case "8", "opensearch":
+		body := map[string]interface{}{
+			"index_patterns": t.Patterns,
+			"priority":       priority,
+			"template": map[string]interface{}{
+				"settings": settings,
+				"mappings": t.mappings("date_nanos"),
+			},
+		}
+		if t.DataStream {
+			body["data_stream"] = map[string]interface{}{}
+		}
+		return "/_index_template/" + t.Name, body, nil
+	default:
+		return "", nil, fmt.Errorf("error: index templates are not supported by elasticsearch version: %s", version)
+	}
+}
+
+// mappings returns the mappings of the template
+func (t Template) mappings(timestamp string) map[string]interface{} {
+	if t.DeadLetter {
+		return deadLetterMappings(timestamp)
+	}
+	return mappings(timestamp)
+}
+
+// mappings maps the fields of the log messages, strings are keywords,
+// except the message itself
+func mappings(timestamp string) map[string]interface{} {
+	keyword := map[string]interface{}{"type": "keyword", "ignore_above": 1024}
+	return map[string]interface{}{
+		"dynamic_templates": []interface{}{
+			map[string]interface{}{
+				"strings_as_keywords": map[string]interface{}{
+					"match_mapping_type": "string",
+					"mapping":            keyword,
+				},
+			},
+		},
+		"properties": map[string]interface{}{
+			"message":             map[string]interface{}{"type": "text"},
+			"source":              keyword,
+			"timestamp":           map[string]interface{}{"type": timestamp},
+			"partial":             map[string]interface{}{"type": "boolean"},
+			"sequence":            map[string]interface{}{"type": "long"},
+			"containerID":         keyword,
+			"containerName":       keyword,
+			"containerEntrypoint": keyword,
+			"containerArgs":       keyword,
+			"containerImageID":    keyword,
+			"containerImageName":  keyword,
+			"containerCreated":    map[string]interface{}{"type": "date"},
+			"containerEnv":        keyword,
+			"containerLabels":     map[string]interface{}{"type": "object"},
+			"daemonName":          keyword,
+			"config":              map[string]interface{}{"type": "object"},
+			"grok":                map[string]interface{}{"type": "object"},
+		},
+	}
+}
+
+// deadLetterMappings maps the wrappers of rejected documents, the original
+// document is kept as a string, which is not indexed
+func deadLetterMappings(timestamp string) map[string]interface{} {
+	keyword := map[string]interface{}{"type": "keyword", "ignore_above": 1024}
+	return map[string]interface{}{
+		"dynamic": false,
+		"properties": map[string]interface{}{
+			"@timestamp": map[string]interface{}{"type": timestamp},
+			"index":      keyword,
+			"status":     map[string]interface{}{"type": "integer"},
+			"type":       keyword,
+			"reason":     map[string]interface{}{"type": "text"},
+			"document":   map[string]interface{}{"type": "text", "index": false},
+		},
+	}
+}
+
+// policyBody returns the ILM policy, which rolls the indices over
+// and deletes them after the given ages
+func policyBody(t Template) map[string]interface{} {
+	hot := map[string]interface{}{}
+	if t.RolloverAge != "" {
+		hot["rollover"] = map[string]interface{}{"max_age": t.RolloverAge}
+	}
+	phases := map[string]interface{}{
+		"hot": map[string]interface{}{"min_age": "0ms", "actions": hot},
+	}
+	if t.DeleteAge != "" {
+		phases["delete"] = map[string]interface{}{
+			"min_age": t.DeleteAge,
+			"actions": map[string]interface{}{"delete": map[string]interface{}{}},
+		}
+	}
+	return map[string]interface{}{"policy": map[string]interface{}{"phases": phases}}
+}
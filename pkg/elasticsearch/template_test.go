@@ -0,0 +1,98 @@
+package elasticsearch
+
+import "testing"
+
+func Test_templateBody(t *testing.T) {
+	tmpl := Template{Name: "docker", Patterns: []string{"docker-*"}, Type: "log", Shards: 1, Replicas: 1, Policy: "logs"}
+	tests := []struct {
+		version    string
+		dataStream bool
+		path       string
+		key        string
+		wantErr    bool
+	}{
+		{version: "5", path: "/_template/docker", key: "template"},
+		{version: "6", path: "/_template/docker", key: "index_patterns"},
+		{version: "7", path: "/_template/docker", key: "index_patterns"},
+		{version: "7", dataStream: true, path: "/_index_template/docker", key: "data_stream"},
+		{version: "8", path: "/_index_template/docker", key: "template"},
+		{version: "8", dataStream: true, path: "/_index_template/docker", key: "data_stream"},
+		{version: "opensearch", path: "/_index_template/docker", key: "template"},
+		{version: "2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			tmpl.DataStream = tt.dataStream
+			path, body, err := templateBody(tt.version, tmpl)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("templateBody() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if path != tt.path {
+				t.Errorf("templateBody() path = %v, want %v", path, tt.path)
+			}
+			if _, exists := body[tt.key]; !tt.wantErr && !exists {
+				t.Errorf("templateBody() body = %v, want key %v", body, tt.key)
+			}
+			// the built-in logs template of elasticsearch has a priority of 100
+			if tt.path == "/_index_template/docker" && body["priority"] != 150 {
+				t.Errorf("templateBody() priority = %v, want 150", body["priority"])
+			}
+		})
+	}
+}
+
+func Test_templateBody_patterns(t *testing.T) {
+	tmpl := Template{Name: "docker", Patterns: []string{"docker-*", "docker-errors-*"}, Type: "log"}
+	if _, _, err := templateBody("5", tmpl); err == nil {
+		t.Errorf("templateBody() error = nil, want an error for several patterns")
+	}
+	_, body, err := templateBody("6", tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patterns := body["index_patterns"].([]string); len(patterns) != 2 {
+		t.Errorf("templateBody() index_patterns = %v, want %v", patterns, tmpl.Patterns)
+	}
+}
+
+func Test_policyBody(t *testing.T) {
+	body := policyBody(Template{RolloverAge: "1d", DeleteAge: "30d"})
+	phases := body["policy"].(map[string]interface{})["phases"].(map[string]interface{})
+	hot := phases["hot"].(map[string]interface{})["actions"].(map[string]interface{})
+	if _, exists := hot["rollover"]; !exists {
+		t.Errorf("policyBody() hot = %v, want rollover", hot)
+	}
+	if _, exists := phases["delete"]; !exists {
+		t.Errorf("policyBody() phases = %v, want delete", phases)
+	}
+}
+
+func Test_templateBody_deadLetter(t *testing.T) {
+	tmpl := Template{Name: "docker-dead-letter", Patterns: []string{"docker-dead-letter"}, Type: "log", Shards: 1, Replicas: 1, DeadLetter: true}
+	for _, version := range []string{"5", "6", "7", "8", "opensearch"} {
+		t.Run(version, func(t *testing.T) {
+			_, body, err := templateBody(version, tmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var m map[string]interface{}
+			switch version {
+			case "5", "6":
+				m = body["mappings"].(map[string]interface{})["log"].(map[string]interface{})
+			case "7":
+				m = body["mappings"].(map[string]interface{})
+			default:
+				m = body["template"].(map[string]interface{})["mappings"].(map[string]interface{})
+				// overlapping templates with the same priority are rejected
+				if body["priority"] != 250 {
+					t.Errorf("templateBody() priority = %v, want 250", body["priority"])
+				}
+			}
+			document := m["properties"].(map[string]interface{})["document"].(map[string]interface{})
+			if document["index"] != false {
+				t.Errorf("templateBody() document = %v, want an unindexed field", document)
+			}
+		})
+	}
+}
@@ -0,0 +1,756 @@
+package v7
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/olivere/elastic"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/deadletter"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/resend"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/elasticsearch/split"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/metrics"
+	"golang.org/x/net/context"
+)
+
+const version = 7
+
+// readSize is the number of documents fetched per request while reading logs
+const readSize = 1000
+
+// tiebreaker sorts documents with the same timestamp by the sequence
+// number written by the plugin, in the order they have been logged
+const tiebreaker = "sequence"
+
+// Elasticsearch ...
+type Elasticsearch struct {
+	*elastic.Client
+	*elastic.BulkProcessor
+	*elastic.BulkProcessorService
+
+	// mu guards the bulk processor against Add and Flush, while it is restarted
+	mu sync.RWMutex
+	// closing is set, while the bulk processor is stopped, the requests,
+	// which fail to be committed meanwhile, are spooled
+	closing int32
+	// restarting is set, until a pending restart has finished
+	restarting int32
+	// closed is set by Close, the bulk processor is not restarted afterwards
+	closed bool
+	// stats are the statistics of the bulk processor before its restarts
+	stats metrics.BulkStats
+}
+
+// NewClient ...
+func NewClient(address, username, password string, timeout time.Duration, sniff bool, tlsConfig *tls.Config, oversize string) (*Elasticsearch, error) {
+
+	url, _ := url.Parse(address)
+	tr := new(http.Transport)
+
+	if url.Scheme == "https" {
+		tr = &http.Transport{
+			TLSClientConfig: tlsConfig,
+		}
+	}
+	// bulk requests, which are too large, are sent in halves
+	client := &http.Client{Transport: &split.Transport{
+		Base:   tr,
+		Policy: oversize,
+	}}
+
+	c, err := elastic.NewClient(
+		elastic.SetURL(address),
+		elastic.SetScheme(url.Scheme),
+		elastic.SetBasicAuth(username, password),
+		elastic.SetHttpClient(client),
+		elastic.SetSniff(sniff),
+		elastic.SetRetrier(NewMyRetrier(timeout)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("elasticsearch: cannot connect to the endpoint: %s\n%v", url, err)
+	}
+	return &Elasticsearch{
+		Client:               c,
+		BulkProcessorService: c.BulkProcessor(),
+	}, nil
+}
+
+// Log sends log messages to elasticsearch, mapping types
+// have been removed, therefore tzpe is ignored
+func (e *Elasticsearch) Log(ctx context.Context, index, tzpe string, msg interface{}) error {
+	_, err := e.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "POST",
+		Path:   "/" + index + "/_doc",
+		Body:   msg,
+	})
+	return err
+}
+
+// Read retrieves the log messages of a container in chronological order
+// and passes the source and the sort values of each document to fn
+func (e *Elasticsearch) Read(ctx context.Context, index, containerID string, since, until time.Time, tail int, fn func([]byte, []interface{}) error) error {
+
+	// the scroll service cannot parse the total hits of elasticsearch 7,
+	// hence all messages are paginated with search after
+	if tail <= 0 {
+		return e.SearchAfter(ctx, index, containerID, since, until, nil, fn)
+	}
+
+	// only the last messages are requested, look them up backwards
+	// and reverse them afterwards
+	source := elastic.NewSearchSource().
+		Query(readQuery(containerID, since, until)).
+		SortBy(readSort(false)...).
+		Size(tail)
+	result, err := e.search(ctx, index, source)
+	if err != nil {
+		return err
+	}
+	for i := len(result.Hits.Hits) - 1; i >= 0; i-- {
+		if err := readHit(result.Hits.Hits[i], fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchAfter retrieves the log messages of a container written after the
+// given sort values and passes the source and sort values of each document to fn
+func (e *Elasticsearch) SearchAfter(ctx context.Context, index, containerID string, since, until time.Time, after []interface{}, fn func([]byte, []interface{}) error) error {
+
+	query := readQuery(containerID, since, until)
+
+	for {
+		source := elastic.NewSearchSource().
+			Query(query).
+			SortBy(readSort(true)...).
+			Size(readSize)
+		if after != nil {
+			source.SearchAfter(after...)
+		}
+
+		result, err := e.search(ctx, index, source)
+		if err != nil {
+			return err
+		}
+		for _, hit := range result.Hits.Hits {
+			if err := readHit(hit, fn); err != nil {
+				return err
+			}
+			after = hit.Sort
+		}
+		if len(result.Hits.Hits) < readSize {
+			return nil
+		}
+	}
+}
+
+// search sends a search request, the total hits are requested as an integer,
+// because the search service does not understand the format of elasticsearch 7
+func (e *Elasticsearch) search(ctx context.Context, index string, source *elastic.SearchSource) (*elastic.SearchResult, error) {
+	body, err := source.Source()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := e.Client.PerformRequest(ctx, elastic.PerformRequestOptions{
+		Method: "POST",
+		Path:   "/" + index + "/_search",
+		Params: url.Values{
+			"ignore_unavailable":     []string{"true"},
+			"rest_total_hits_as_int": []string{"true"},
+		},
+		Body: body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers are kept as they are, because the sort values are sent back
+	result := new(elastic.SearchResult)
+	dec := json.NewDecoder(bytes.NewReader(res.Body))
+	dec.UseNumber()
+	if err := dec.Decode(result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// readQuery filters the log messages of a container by their timestamp
+func readQuery(containerID string, since, until time.Time) elastic.Query {
+	query := elastic.NewBoolQuery().Filter(elastic.NewTermQuery("containerID", containerID))
+	if !since.IsZero() || !until.IsZero() {
+		timestamp := elastic.NewRangeQuery("timestamp")
+		if !since.IsZero() {
+			timestamp.Gte(since)
+		}
+		if !until.IsZero() {
+			timestamp.Lte(until)
+		}
+		query.Filter(timestamp)
+	}
+	return query
+}
+
+// readSort orders the log messages by timestamp, documents with the
+// same timestamp are ordered by the tiebreaker. Documents without a
+// sequence number, i.e. of older versions, are sorted last.
+func readSort(ascending bool) []elastic.Sorter {
+	return []elastic.Sorter{
+		elastic.NewFieldSort("timestamp").Order(ascending).UnmappedType("date"),
+		elastic.NewFieldSort(tiebreaker).Order(ascending).UnmappedType("long"),
+	}
+}
+
+func readHit(hit *elastic.SearchHit, fn func([]byte, []interface{}) error) error {
+	if hit.Source == nil {
+		return nil
+	}
+	return fn(*hit.Source, hit.Sort)
+}
+
+func (e *Elasticsearch) NewBulkProcessorService(ctx context.Context, workers, actions, size int, flushInterval, timeout time.Duration, retries int, deadLetter string, stats bool, spool func([]string) error, m *metrics.Container, log *logrus.Entry) error {
+
+	beforeFunc := func(executionId int64, bulkableRequests []elastic.BulkableRequest) {
+		m.BulkStarted(executionId)
+	}
+
+	afterFunc := func(executionId int64, bulkableRequests []elastic.BulkableRequest, response *elastic.BulkResponse, err error) {
+
+		m.BulkFinished(executionId)
+		if response != nil {
+			for _, item := range response.Items {
+				for _, result := range item {
+					m.Item(result.Status)
+				}
+			}
+		}
+
+		if response != nil && response.Errors {
+			// map all requests in order to log the one who's failed
+			requests, perr := parseRequest(bulkableRequests)
+			if perr != nil {
+				log.WithError(perr).Error("could not parse request")
+			}
+
+			// find out the reasons of the failure
+			for i, result := range items(response) {
+				// rejected requests are logged, if they cannot be resent
+				if result.Error == nil || (retries > 0 && resend.Retryable(result.Status)) {
+					continue
+				}
+				log.WithFields(logrus.Fields{
+					"workerId":  executionId,
+					"requestId": result.Id,
+					"request":   requests.ByPosition(i),
+					"reason":    result.Error.Reason,
+					"status":    result.Status,
+				}).Error("response error message and status code")
+			}
+
+			if deadLetter != "" {
+				if derr := e.deadLetter(ctx, deadLetter, sourceLines(bulkableRequests), response); derr != nil {
+					log.WithError(derr).WithField("workerId", executionId).Error("could not index rejected requests into the dead-letter index")
+				}
+			}
+
+			if retries > 0 {
+				rejected, rerr := e.resend(ctx, bulkableRequests, response, retries, timeout, m)
+				if len(rejected) > 0 {
+					log.WithError(rerr).WithFields(logrus.Fields{
+						"workerId": executionId,
+						"requests": len(rejected) / 2,
+					}).Error("could not resend rejected requests")
+
+					if spool != nil {
+						if serr := spool(rejected); serr != nil {
+							log.WithError(serr).Error("could not spool requests")
+							m.Dropped(len(rejected) / 2)
+						}
+					}
+				}
+			}
+		}
+
+		if err != nil {
+			log.WithError(err).WithFields(logrus.Fields{
+				"workerId": executionId,
+				"requests": bulkableRequests,
+				"response": response,
+			}).Error("after func")
+
+			// keep the requests on disk, they are replayed once elasticsearch is available.
+			// The bulk processor keeps failed requests in memory and commits them again,
+			// hence it is restarted, which commits them a last time and spools them.
+			if spool != nil {
+				if atomic.LoadInt32(&e.closing) == 1 {
+					if serr := spool(sourceLines(bulkableRequests)); serr != nil {
+						log.WithError(serr).Error("could not spool requests")
+						m.Dropped(len(bulkableRequests))
+					}
+				} else if atomic.CompareAndSwapInt32(&e.restarting, 0, 1) {
+					go e.restart(ctx, log)
+				}
+			}
+		}
+	}
+	// TODO: differentiate from connectionTimeout and bulkTimeout
+	backoff := retryBackoff{
+		Backoff: elastic.NewExponentialBackoff(200*time.Millisecond, timeout),
+		metrics: m,
+	}
+
+	p, err := e.BulkProcessorService.
+		Workers(workers).
+		BulkActions(actions).         // commit if # requests >= BulkSize
+		BulkSize(size).               // commit if size of requests >= 1 MB
+		FlushInterval(flushInterval). // commit every given interval
+		Stats(stats).                 // collect stats
+		Backoff(backoff).
+		Before(beforeFunc).
+		After(afterFunc).
+		Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	e.BulkProcessor = p
+
+	return nil
+}
+
+// Add adds a typeless index request of the op type index or create
+// to the bulk processor, tzpe is ignored
+func (e *Elasticsearch) Add(index, tzpe, pipeline, opType, id string, msg interface{}) error {
+	r := elastic.NewBulkIndexRequest().OpType(opType).Index(index).Pipeline(pipeline).Doc(msg).Id(id)
+	e.mu.RLock()
+	e.BulkProcessor.Add(r)
+	e.mu.RUnlock()
+
+	return nil
+}
+
+// Close commits the queued requests and stops the bulk processor
+func (e *Elasticsearch) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	atomic.StoreInt32(&e.closing, 1)
+	return e.BulkProcessor.Close()
+}
+
+func (e *Elasticsearch) Flush() error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.BulkProcessor.Flush()
+}
+
+// restart stops the bulk processor, which commits the failed requests a
+// last time and spools them, and starts it again without any requests
+func (e *Elasticsearch) restart(ctx context.Context, log *logrus.Entry) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	defer atomic.StoreInt32(&e.restarting, 0)
+
+	if e.closed {
+		return
+	}
+
+	atomic.StoreInt32(&e.closing, 1)
+	e.BulkProcessor.Close()
+	e.stats = e.bulkStats()
+	e.stats.Queued = 0
+	atomic.StoreInt32(&e.closing, 0)
+
+	if err := e.BulkProcessor.Start(ctx); err != nil {
+		log.WithError(err).Error("could not restart bulk processor")
+	}
+}
+
+// Stats returns the statistics of the bulk processor
+func (e *Elasticsearch) Stats() metrics.BulkStats {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	return e.bulkStats()
+}
+
+// bulkStats adds the statistics of the bulk processor to the ones
+// before its restarts, the lock must be held
+func (e *Elasticsearch) bulkStats() metrics.BulkStats {
+	if e.BulkProcessor == nil {
+		return e.stats
+	}
+
+	stats := e.BulkProcessor.Stats()
+	bulkStats := metrics.BulkStats{
+		Flushed:   e.stats.Flushed + stats.Flushed,
+		Committed: e.stats.Committed + stats.Committed,
+		Indexed:   e.stats.Indexed + stats.Indexed,
+		Succeeded: e.stats.Succeeded + stats.Succeeded,
+		Failed:    e.stats.Failed + stats.Failed,
+	}
+	for _, worker := range stats.Workers {
+		bulkStats.Queued += worker.Queued
+	}
+	return bulkStats
+}
+
+// resend retries the requests rejected with 429 or 503, the lines of the
+// requests, which are still rejected, are returned
+func (e *Elasticsearch) resend(ctx context.Context, requests []elastic.BulkableRequest, response *elastic.BulkResponse, retries int, timeout time.Duration, m *metrics.Container) ([]string, error) {
+	return resend.Items(sourceLines(requests), statuses(response), retries, 200*time.Millisecond, timeout, func(lines []string) ([]int, error) {
+		m.Retry()
+		bulk := e.Client.Bulk()
+		for i := 0; i+1 < len(lines); i += 2 {
+			bulk.Add(rawRequest(lines[i : i+2]))
+		}
+		response, err := bulk.Do(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range response.Items {
+			for _, result := range item {
+				m.Item(result.Status)
+			}
+		}
+		return statuses(response), nil
+	})
+}
+
+// deadLetter indexes the documents, which have been rejected, e.g. because
+// of mapping conflicts, wrapped into the dead-letter index
+func (e *Elasticsearch) deadLetter(ctx context.Context, index string, lines []string, response *elastic.BulkResponse) error {
+	var items []deadletter.Item
+	for _, item := range response.Items {
+		for _, result := range item {
+			i := deadletter.Item{Status: result.Status}
+			if result.Error != nil {
+				i.Type, i.Reason = result.Error.Type, result.Error.Reason
+			}
+			items = append(items, i)
+		}
+	}
+
+	wrappers, err := deadletter.Lines(index, lines, items)
+	if err != nil || len(wrappers) == 0 {
+		return err
+	}
+
+	bulk := e.Client.Bulk()
+	for i := 0; i+1 < len(wrappers); i += 2 {
+		bulk.Add(rawRequest(wrappers[i : i+2]))
+	}
+	response, err = bulk.Do(ctx)
+	if err != nil {
+		return err
+	}
+	if response.Errors {
+		return fmt.Errorf("error: %d documents have been rejected by the dead-letter index", len(response.Failed()))
+	}
+	return nil
+}
+
+// items returns the results of the items in the order of the requests
+func items(response *elastic.BulkResponse) []*elastic.BulkResponseItem {
+	results := make([]*elastic.BulkResponseItem, 0, len(response.Items))
+	for _, item := range response.Items {
+		for _, result := range item {
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// statuses returns the statuses of the items in the order of the requests
+func statuses(response *elastic.BulkResponse) []int {
+	statuses := make([]int, 0, len(response.Items))
+	for _, item := range response.Items {
+		for _, result := range item {
+			statuses = append(statuses, result.Status)
+		}
+	}
+	return statuses
+}
+
+// Replay sends the lines of spooled bulk requests to elasticsearch. It fails,
+// if documents have been rejected temporarily, so that they are replayed again.
+// Documents, which have been rejected permanently, are indexed into the
+// dead-letter index, if it is not empty, otherwise their number is returned.
+func (e *Elasticsearch) Replay(ctx context.Context, lines []string, deadLetter string) (int, error) {
+	bulk := e.Client.Bulk()
+	for i := 0; i+1 < len(lines); i += 2 {
+		bulk.Add(rawRequest(lines[i : i+2]))
+	}
+	if bulk.NumberOfActions() == 0 {
+		return 0, nil
+	}
+	response, err := bulk.Do(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !response.Errors {
+		return 0, nil
+	}
+
+	var dropped int
+	for _, status := range statuses(response) {
+		switch {
+		case status >= 200 && status <= 299 || status == 409:
+			// the document has been indexed, e.g. by a previous replay
+		case resend.Retryable(status):
+			return 0, fmt.Errorf("error: documents have been rejected temporarily with status %d", status)
+		case deadLetter == "" || !deadletter.Rejected(status):
+			dropped++
+		}
+	}
+
+	if deadLetter != "" {
+		if err := e.deadLetter(ctx, deadLetter, lines, response); err != nil {
+			return 0, err
+		}
+	}
+	return dropped, nil
+}
+
+// retryBackoff counts the retries of the bulk processor
+type retryBackoff struct {
+	elastic.Backoff
+	metrics *metrics.Container
+}
+
+func (b retryBackoff) Next(retry int) (time.Duration, bool) {
+	wait, ok := b.Backoff.Next(retry)
+	if ok {
+		b.metrics.Retry()
+	}
+	return wait, ok
+}
+
+// rawRequest is a bulk request, which has already been serialized
+type rawRequest []string
+
+func (r rawRequest) String() string {
+	return strings.Join(r, "\n")
+}
+
+func (r rawRequest) Source() ([]string, error) {
+	return r, nil
+}
+
+// sourceLines returns the bulk api lines of the requests
+func sourceLines(bulkableRequests []elastic.BulkableRequest) []string {
+	var lines []string
+	for _, bulkableRequest := range bulkableRequests {
+		source, err := bulkableRequest.Source()
+		if err != nil {
+			continue
+		}
+		lines = append(lines, source...)
+	}
+	return lines
+}
+
+// Stop stops the background processes that the client is running,
+// i.e. sniffing the cluster periodically and running health checks
+// on the nodes.
+func (e *Elasticsearch) Stop() {
+	e.Client.Stop()
+}
+
+// Version reports the client version
+func (e *Elasticsearch) Version() int {
+	return version
+}
+
+// bulkSources are the sources of the bulk requests in order, the items
+// of a bulk response are returned in the order of the requests
+type bulkSources []string
+
+func parseRequest(bulkableRequests []elastic.BulkableRequest) (bulkSources, error) {
+	sources := make(bulkSources, 0, len(bulkableRequests))
+	for _, bulkableRequest := range bulkableRequests {
+		lines, err := bulkableRequest.Source()
+		if err != nil {
+			return nil, err
+		}
+		// the action line is followed by the source
+		source := ""
+		if len(lines) > 1 {
+			source = lines[1]
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// ByPosition returns the source of the request at the position of an item
+func (s bulkSources) ByPosition(i int) string {
+	if i < 0 || i >= len(s) {
+		return "request not found"
+	}
+	return s[i]
+}
+
+// BulkService ...
+type BulkService struct {
+	client         *Elasticsearch
+	bulkService    *elastic.BulkService
+	initialTimeout time.Duration
+	// requests are kept in order, so that the rejected ones can be resent
+	requests []elastic.BulkableRequest
+	retries  int
+	timeout  time.Duration
+}
+
+// Bulk creates a service
+func Bulk(client *Elasticsearch, timeout time.Duration, actions int) *BulkService {
+	return &BulkService{
+		client:         client,
+		bulkService:    elastic.NewBulkService(client.Client),
+		initialTimeout: 100 * time.Millisecond,
+		requests:       make([]elastic.BulkableRequest, 0, actions),
+		retries:        3, // the default of elasticsearch-bulk-retries
+		timeout:        timeout,
+	}
+}
+
+// Add adds bulkable requests, i.e. BulkIndexRequest, BulkUpdateRequest,
+// and/or BulkDeleteRequest. Mapping types have been removed, therefore
+// tzpe is ignored.
+func (e *BulkService) Add(index, tzpe, pipeline, opType, id string, msg interface{}) {
+	r := elastic.NewBulkIndexRequest().OpType(opType).Index(index).Pipeline(pipeline).Doc(msg).Id(id)
+
+	e.requests = append(e.requests, r)
+
+	e.bulkService.Add(r)
+}
+
+// CommitRequired returns true if the service has to commit its
+// bulk requests. This can be either because the number of actions
+// or the estimated size in bytes is larger than specified in the
+// BulkProcessorService.
+func (e *BulkService) CommitRequired(actions int, bulkSize int) bool {
+	if actions >= 0 && e.bulkService.NumberOfActions() >= actions {
+		return true
+	}
+	if bulkSize >= 0 && e.bulkService.EstimatedSizeInBytes() >= int64(bulkSize) {
+		return true
+	}
+	return false
+}
+
+// Do sends the batched requests to Elasticsearch. Note that, when successful,
+// you can reuse the BulkService for the next batch as the list of bulk
+// requests is cleared on success.
+//
+//	{
+//	  "took":3,
+//	  "errors":false,
+//	  "items":[{
+//	    "index":{
+//	      "_index":"index1",
+//	      "_id":"1",
+//	      "_version":3,
+//	      "status":201
+//	    }
+//	  }
+//	}
+func (e *BulkService) Do(ctx context.Context) (interface{}, int, bool, error) {
+
+	var bulkResponse *elastic.BulkResponse
+
+	// commitFunc will commit bulk requests and, on failure, be retried
+	// via exponential backoff
+	commitFunc := func() error {
+		var err error
+		bulkResponse, err = e.bulkService.Do(ctx)
+		return err
+	}
+	// notifyFunc will be called if retry fails
+	notifyFunc := func(_ error) {
+		// log.Errorf("elastic: bulk processor failed but may retry: %v", err)
+	}
+
+	policy := elastic.NewExponentialBackoff(e.initialTimeout, e.timeout)
+	err := elastic.RetryNotify(commitFunc, policy, notifyFunc)
+	if err != nil {
+		return nil, 0, true, err
+	}
+
+	// the items rejected with 429 or 503 are resent with a backoff
+	rejected, err := e.client.resend(ctx, e.requests, bulkResponse, e.retries, e.timeout, nil)
+	e.requests = e.requests[:0]
+	if len(rejected) > 0 {
+		if err == nil {
+			err = fmt.Errorf("error: %d requests are still rejected", len(rejected)/2)
+		}
+		return bulkResponse, bulkResponse.Took, true, err
+	}
+
+	return bulkResponse, bulkResponse.Took, bulkResponse.Errors, nil
+}
+
+// Errors parses a BulkResponse and returns the reason of the failure requests
+//
+//	{
+//		"error" : {
+//		  "root_cause" : [
+//			{
+//			  "type" : "illegal_argument_exception",
+//			  "reason" : "Failed to parse int parameter [size] with value [surprise_me]"
+//			}
+//		  ],
+//		  "type" : "illegal_argument_exception",
+//		  "reason" : "Failed to parse int parameter [size] with value [surprise_me]",
+//		  "caused_by" : {
+//			"type" : "number_format_exception",
+//			"reason" : "For input string: \"surprise_me\""
+//		  }
+//		},
+//		"status" : 400
+//	  }
+func (e *BulkService) Errors(bulkResponse interface{}) []map[int]string {
+
+	if bulkResponse == nil {
+		return nil
+	}
+	if bulkResponse.(*elastic.BulkResponse).Items == nil {
+		return nil
+	}
+
+	var reason []map[int]string
+	for _, item := range bulkResponse.(*elastic.BulkResponse).Items {
+		for _, result := range item {
+			if result.Error == nil {
+				continue
+			}
+			reason = append(reason, map[int]string{
+				result.Status: result.Error.Reason,
+			})
+		}
+	}
+	return reason
+}
+
+// EstimatedSizeInBytes returns the estimated size of all bulkable
+// requests added via Add.
+func (e *BulkService) EstimatedSizeInBytes() int64 {
+	return e.bulkService.EstimatedSizeInBytes()
+}
+
+// NumberOfActions returns the number of bulkable requests that need to
+// be sent to Elasticsearch on the next batch.
+func (e *BulkService) NumberOfActions() int {
+	return e.bulkService.NumberOfActions()
+}
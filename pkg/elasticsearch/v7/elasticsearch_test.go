@@ -0,0 +1,257 @@
+package v7
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/rchicoli/docker-log-elasticsearch/pkg/metrics"
+	"golang.org/x/net/context"
+)
+
+func TestElasticsearch_Read(t *testing.T) {
+	var body []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/docker-*/_search" {
+			body, _ = ioutil.ReadAll(r.Body)
+			w.Write([]byte(`{"hits":{"total":3,"hits":[
+				{"_id":"c","_source":{"message":"first"},"sort":[1514764800000,1]},
+				{"_id":"a","_source":{"message":"second"},"sort":[1514764800000,2]},
+				{"_id":"b","_source":{"message":"third"},"sort":[1514764800000,3]}
+			]}}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	e, err := NewClient(ts.URL, "", "", time.Second, false, nil, "drop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var messages, sequences []string
+	err = e.Read(context.Background(), "docker-*", "abc", time.Time{}, time.Time{}, -1, func(source []byte, sort []interface{}) error {
+		messages = append(messages, string(source))
+		sequences = append(sequences, fmt.Sprint(sort[1]))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`{"message":"first"}`, `{"message":"second"}`, `{"message":"third"}`}
+	if strings.Join(messages, ",") != strings.Join(want, ",") {
+		t.Errorf("Read() = %v, want %v", messages, want)
+	}
+	if strings.Join(sequences, ",") != "1,2,3" {
+		t.Errorf("Read() sequences = %v, want 1,2,3", sequences)
+	}
+
+	// documents with the same timestamp are ordered by the sequence
+	// number, because the IDs are random
+	var search struct {
+		Sort []map[string]map[string]interface{} `json:"sort"`
+	}
+	if err := json.Unmarshal(body, &search); err != nil {
+		t.Fatal(err)
+	}
+	if len(search.Sort) != 2 || search.Sort[1]["sequence"]["unmapped_type"] != "long" {
+		t.Errorf("Read() sort = %s, want the sequence as tiebreaker", body)
+	}
+}
+
+func TestElasticsearch_SearchAfter(t *testing.T) {
+	var bodies [][]byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/docker-*/_search" {
+			body, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, body)
+			w.Write([]byte(`{"hits":{"total":1,"hits":[
+				{"_id":"a","_source":{"message":"late"},"sort":[1514764800000,4]}
+			]}}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer ts.Close()
+
+	e, err := NewClient(ts.URL, "", "", time.Second, false, nil, "drop")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cursor []interface{}
+	after := []interface{}{1514764800000, 3}
+	err = e.SearchAfter(context.Background(), "docker-*", "abc", time.Time{}, time.Time{}, after, func(source []byte, sort []interface{}) error {
+		cursor = sort
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cursor consists of the timestamp and the sequence number
+	var search struct {
+		SearchAfter []int64 `json:"search_after"`
+	}
+	if len(bodies) != 1 {
+		t.Fatalf("SearchAfter() sent %d requests, want 1", len(bodies))
+	}
+	if err := json.Unmarshal(bodies[0], &search); err != nil {
+		t.Fatal(err)
+	}
+	if len(search.SearchAfter) != 2 || search.SearchAfter[0] != 1514764800000 || search.SearchAfter[1] != 3 {
+		t.Errorf("SearchAfter() search_after = %v, want [1514764800000 3]", search.SearchAfter)
+	}
+	if len(cursor) != 2 || fmt.Sprint(cursor[1]) != "4" {
+		t.Errorf("SearchAfter() cursor = %v, want the sequence 4", cursor)
+	}
+	// date_nanos and long sort values must not lose their precision
+	if fmt.Sprint(cursor[0]) != "1514764800000" {
+		t.Errorf("SearchAfter() cursor = %v, want the timestamp 1514764800000", cursor)
+	}
+}
+
+func TestElasticsearch_outage(t *testing.T) {
+	var mu sync.Mutex
+	down := true
+	var indexed, spooled []string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":"unavailable","status":503}`))
+			return
+		}
+		var items []string
+		for i := 1; i < len(lines); i += 2 {
+			indexed = append(indexed, lines[i])
+			items = append(items, `{"index":{"status":201}}`)
+		}
+		fmt.Fprintf(w, `{"took":1,"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	}))
+	defer ts.Close()
+
+	e, err := NewClient(ts.URL, "", "", time.Second, false, nil, "drop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spool := func(lines []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		spooled = append(spooled, lines...)
+		return nil
+	}
+	m := metrics.New("outage")
+	defer metrics.Remove("outage")
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+	if err := e.NewBulkProcessorService(context.Background(), 1, 1, -1, 0, 100*time.Millisecond, 0, "", true, spool, m, logrus.NewEntry(logger)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the failed request is spooled instead of being kept in memory
+	e.Add("docker", "", "", "index", "", map[string]string{"message": "first"})
+	for i := 0; ; i++ {
+		mu.Lock()
+		n := len(spooled)
+		mu.Unlock()
+		if n == 2 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("spooled = %v, want the first request", spooled)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	mu.Lock()
+	down = false
+	mu.Unlock()
+
+	e.Add("docker", "", "", "index", "", map[string]string{"message": "second"})
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`{"message":"second"}`}; strings.Join(indexed, ",") != strings.Join(want, ",") {
+		t.Errorf("indexed = %v, want %v", indexed, want)
+	}
+
+	// the spool is replayed, once elasticsearch has recovered
+	if _, err := e.Replay(context.Background(), spooled, ""); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{`{"message":"second"}`, `{"message":"first"}`}; strings.Join(indexed, ",") != strings.Join(want, ",") {
+		t.Errorf("indexed = %v, want %v", indexed, want)
+	}
+}
+
+func TestElasticsearch_Replay(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		deadLetter     string
+		wantDropped    int
+		wantDeadLetter bool
+		wantErr        bool
+	}{
+		{name: "indexed", status: 201},
+		{name: "existing", status: 409},
+		{name: "rejected temporarily", status: 429, wantErr: true},
+		{name: "mapping", status: 400, wantDropped: 1},
+		{name: "mapping with dead-letter", status: 400, deadLetter: "dead-letter", wantDeadLetter: true},
+		{name: "too large with dead-letter", status: 413, deadLetter: "dead-letter", wantDropped: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deadLettered bool
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/_bulk" {
+					w.Write([]byte(`{"version":{"number":"7.10.0"}}`))
+					return
+				}
+				body, _ := ioutil.ReadAll(r.Body)
+				if strings.Contains(string(body), `"_index":"dead-letter"`) {
+					deadLettered = true
+					w.Write([]byte(`{"took":1,"errors":false,"items":[{"create":{"status":201}}]}`))
+					return
+				}
+				fmt.Fprintf(w, `{"took":1,"errors":%t,"items":[{"index":{"_index":"docker","_id":"1","status":%d,"error":{"type":"mapper_parsing_exception","reason":"failed"}}}]}`, tt.status > 299, tt.status)
+			}))
+			defer ts.Close()
+
+			e, err := NewClient(ts.URL, "", "", time.Second, false, nil, "drop")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines := []string{`{"index":{"_index":"docker","_id":"1"}}`, `{"message":"hello"}`}
+			dropped, err := e.Replay(context.Background(), lines, tt.deadLetter)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Replay() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if dropped != tt.wantDropped {
+				t.Errorf("Replay() dropped = %v, want %v", dropped, tt.wantDropped)
+			}
+			if deadLettered != tt.wantDeadLetter {
+				t.Errorf("Replay() dead-letter = %v, want %v", deadLettered, tt.wantDeadLetter)
+			}
+		})
+	}
+}
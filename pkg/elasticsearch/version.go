@@ -0,0 +1,107 @@
+package elasticsearch
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rootInfo is the response of the root endpoint
+type rootInfo struct {
+	Version struct {
+		Number       string `json:"number"`
+		Distribution string `json:"distribution"`
+	} `json:"version"`
+}
+
+// Cluster is the version of a cluster reported by its root endpoint
+type Cluster struct {
+	Number       string
+	Distribution string
+}
+
+// GetCluster asks the root endpoint for the version of the cluster
+func GetCluster(url, username, password string, timeout time.Duration, tlsConfig *tls.Config) (Cluster, error) {
+
+	info, err := root(url, username, password, timeout, tlsConfig)
+	if err != nil {
+		return Cluster{}, fmt.Errorf("error: detecting elasticsearch version: %q", err)
+	}
+
+	return Cluster{Number: info.Version.Number, Distribution: info.Version.Distribution}, nil
+}
+
+// Version returns the elasticsearch-version, which matches the cluster
+func (c Cluster) Version() (string, error) {
+	return matchVersion(c.Number, c.Distribution)
+}
+
+// CheckDataStreams returns an error, if the cluster does not support
+// data streams, i.e. elasticsearch before 7.9
+func (c Cluster) CheckDataStreams() error {
+	return supportsDataStreams(c.Number, c.Distribution)
+}
+
+// DetectVersion asks the root endpoint for the version number of the
+// cluster and returns the matching elasticsearch-version
+func DetectVersion(url, username, password string, timeout time.Duration, tlsConfig *tls.Config) (string, error) {
+
+	cluster, err := GetCluster(url, username, password, timeout, tlsConfig)
+	if err != nil {
+		return "", err
+	}
+
+	return cluster.Version()
+}
+
+// root requests the information of the root endpoint
+func root(url, username, password string, timeout time.Duration, tlsConfig *tls.Config) (rootInfo, error) {
+	var info rootInfo
+	data, err := request("GET", url, "/", username, password, timeout, tlsConfig, nil)
+	if err != nil {
+		return info, err
+	}
+	err = json.Unmarshal(data, &info)
+	return info, err
+}
+
+// supportsDataStreams returns an error, if the version number of a cluster
+// does not support data streams. Opensearch supports them since 1.0.
+func supportsDataStreams(number, distribution string) error {
+	if distribution == "opensearch" {
+		return nil
+	}
+
+	parts := strings.SplitN(number, ".", 3)
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("error: parsing elasticsearch version %q: %q", number, err)
+	}
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	if major < 7 || (major == 7 && minor < 9) {
+		return fmt.Errorf("error: elasticsearch version %q of the cluster does not support data streams, they require 7.9 or above", number)
+	}
+	return nil
+}
+
+// matchVersion returns the client version, which supports the version
+// number of a cluster
+func matchVersion(number, distribution string) (string, error) {
+	if distribution == "opensearch" {
+		return "opensearch", nil
+	}
+
+	major := strings.SplitN(number, ".", 2)[0]
+	switch major {
+	case "1", "2", "5", "6", "7", "8":
+		return major, nil
+	default:
+		return "", fmt.Errorf("error: elasticsearch version %q of the cluster is not supported, supported versions are 1.x, 2.x, 5.x, 6.x, 7.x, 8.x and opensearch", number)
+	}
+}
@@ -0,0 +1,58 @@
+package elasticsearch
+
+import "testing"
+
+func Test_matchVersion(t *testing.T) {
+	tests := []struct {
+		number       string
+		distribution string
+		want         string
+		wantErr      bool
+	}{
+		{number: "1.7.6", want: "1"},
+		{number: "2.4.6", want: "2"},
+		{number: "5.6.16", want: "5"},
+		{number: "6.8.23", want: "6"},
+		{number: "7.17.9", want: "7"},
+		{number: "8.11.1", want: "8"},
+		{number: "2.11.0", distribution: "opensearch", want: "opensearch"},
+		{number: "0.90.13", wantErr: true},
+		{number: "9.0.0", wantErr: true},
+		{number: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			got, err := matchVersion(tt.number, tt.distribution)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("matchVersion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("matchVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_supportsDataStreams(t *testing.T) {
+	tests := []struct {
+		number       string
+		distribution string
+		wantErr      bool
+	}{
+		{number: "6.8.23", wantErr: true},
+		{number: "7.8.1", wantErr: true},
+		{number: "7.9.0"},
+		{number: "7.17.9"},
+		{number: "8.11.1"},
+		{number: "1.3.0", distribution: "opensearch"},
+		{number: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.number, func(t *testing.T) {
+			if err := supportsDataStreams(tt.number, tt.distribution); (err != nil) != tt.wantErr {
+				t.Errorf("supportsDataStreams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const namespace = "docker_log_elasticsearch"
+
+// flushBuckets are the upper bounds of the flush latency histogram in seconds
+var flushBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var registry = struct {
+	mu         sync.Mutex
+	containers map[string]*Container
+}{
+	containers: make(map[string]*Container),
+}
+
+// BulkStats is a snapshot of the statistics of a bulk processor
+type BulkStats struct {
+	Flushed   int64
+	Committed int64
+	Indexed   int64
+	Succeeded int64
+	Failed    int64
+	Queued    int64
+}
+
+// Container collects the metrics of a container. All methods
+// can be called on a nil pointer, then nothing is collected.
+type Container struct {
+	containerID string
+
+	linesRead    int64
+	linesParsed  int64
+	grokFailures int64
+	bulkRequests int64
+	retries      int64
+	dropped      int64
+
+	mu      sync.Mutex
+	started map[int64]time.Time
+	indexed int64
+	failed  map[int]int64
+	flush   histogram
+	stats   func() BulkStats
+}
+
+type histogram struct {
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+// New registers the metrics of a container, the previous ones are replaced
+func New(containerID string) *Container {
+	c := &Container{
+		containerID: containerID,
+		started:     make(map[int64]time.Time),
+		failed:      make(map[int]int64),
+		flush:       histogram{buckets: make([]int64, len(flushBuckets))},
+	}
+
+	registry.mu.Lock()
+	registry.containers[containerID] = c
+	registry.mu.Unlock()
+
+	return c
+}
+
+// Remove unregisters the metrics of a container
+func Remove(containerID string) {
+	registry.mu.Lock()
+	delete(registry.containers, containerID)
+	registry.mu.Unlock()
+}
+
+// LineRead counts a line read from the docker stream
+func (c *Container) LineRead() {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(&c.linesRead, 1)
+}
+
+// LineParsed counts a line passed to the bulk processor
+func (c *Container) LineParsed() {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(&c.linesParsed, 1)
+}
+
+// GrokFailure counts a line, which could not be parsed by grok
+func (c *Container) GrokFailure() {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(&c.grokFailures, 1)
+}
+
+// Retry counts a retry of a bulk request
+func (c *Container) Retry() {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(&c.retries, 1)
+}
+
+// Dropped counts documents, which have been discarded
+func (c *Container) Dropped(documents int) {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(&c.dropped, int64(documents))
+}
+
+// BulkStarted records the start of a bulk request
+func (c *Container) BulkStarted(executionID int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.started[executionID] = time.Now()
+	c.mu.Unlock()
+}
+
+// BulkFinished counts a bulk request and observes its latency
+func (c *Container) BulkFinished(executionID int64) {
+	if c == nil {
+		return
+	}
+	atomic.AddInt64(&c.bulkRequests, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	started, exists := c.started[executionID]
+	if !exists {
+		return
+	}
+	delete(c.started, executionID)
+	c.flush.observe(time.Since(started).Seconds())
+}
+
+// Item counts an item of a bulk response by its status code
+func (c *Container) Item(status int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if status >= 200 && status < 300 {
+		c.indexed++
+		return
+	}
+	c.failed[status]++
+}
+
+// SetStats sets the function, which returns the bulk processor statistics
+func (c *Container) SetStats(fn func() BulkStats) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	c.stats = fn
+	c.mu.Unlock()
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range flushBuckets {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Handler serves the metrics of all containers in the prometheus text format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		bw := bufio.NewWriter(w)
+		Write(bw)
+		bw.Flush()
+	})
+}
+
+// Write writes the metrics of all containers in the prometheus text format
+func Write(w io.Writer) {
+
+	registry.mu.Lock()
+	containers := make([]*Container, 0, len(registry.containers))
+	for _, c := range registry.containers {
+		containers = append(containers, c)
+	}
+	registry.mu.Unlock()
+
+	sort.Slice(containers, func(i, j int) bool { return containers[i].containerID < containers[j].containerID })
+
+	stats := make([]BulkStats, len(containers))
+	for i, c := range containers {
+		c.mu.Lock()
+		fn := c.stats
+		c.mu.Unlock()
+		if fn != nil {
+			stats[i] = fn()
+		}
+	}
+
+	counter := func(name, help string, value func(c *Container, s BulkStats) int64) {
+		header(w, name, help, "counter")
+		for i, c := range containers {
+			fmt.Fprintf(w, "%s_%s{container_id=%q} %d\n", namespace, name, c.containerID, value(c, stats[i]))
+		}
+	}
+
+	counter("lines_read_total", "Number of lines read from the docker stream.", func(c *Container, _ BulkStats) int64 {
+		return atomic.LoadInt64(&c.linesRead)
+	})
+	counter("lines_parsed_total", "Number of lines parsed and passed to the bulk processor.", func(c *Container, _ BulkStats) int64 {
+		return atomic.LoadInt64(&c.linesParsed)
+	})
+	counter("grok_failures_total", "Number of lines, which could not be parsed by grok.", func(c *Container, _ BulkStats) int64 {
+		return atomic.LoadInt64(&c.grokFailures)
+	})
+	counter("bulk_requests_total", "Number of bulk requests sent to elasticsearch.", func(c *Container, _ BulkStats) int64 {
+		return atomic.LoadInt64(&c.bulkRequests)
+	})
+	counter("bulk_retries_total", "Number of retries of bulk requests.", func(c *Container, _ BulkStats) int64 {
+		return atomic.LoadInt64(&c.retries)
+	})
+	counter("documents_dropped_total", "Number of documents, which have been discarded.", func(c *Container, _ BulkStats) int64 {
+		return atomic.LoadInt64(&c.dropped)
+	})
+	counter("bulk_items_indexed_total", "Number of bulk items indexed successfully.", func(c *Container, _ BulkStats) int64 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.indexed
+	})
+	counter("bulk_processor_flushed_total", "Number of times the flush interval has been invoked.", func(_ *Container, s BulkStats) int64 {
+		return s.Flushed
+	})
+	counter("bulk_processor_committed_total", "Number of times the workers committed bulk requests.", func(_ *Container, s BulkStats) int64 {
+		return s.Committed
+	})
+	counter("bulk_processor_succeeded_total", "Number of requests reported as successful by the bulk processor.", func(_ *Container, s BulkStats) int64 {
+		return s.Succeeded
+	})
+	counter("bulk_processor_failed_total", "Number of requests reported as failed by the bulk processor.", func(_ *Container, s BulkStats) int64 {
+		return s.Failed
+	})
+
+	header(w, "bulk_items_failed_total", "Number of bulk items, which failed, by status code.", "counter")
+	for _, c := range containers {
+		c.mu.Lock()
+		statuses := make([]int, 0, len(c.failed))
+		for status := range c.failed {
+			statuses = append(statuses, status)
+		}
+		sort.Ints(statuses)
+		for _, status := range statuses {
+			fmt.Fprintf(w, "%s_bulk_items_failed_total{container_id=%q,status=\"%d\"} %d\n", namespace, c.containerID, status, c.failed[status])
+		}
+		c.mu.Unlock()
+	}
+
+	header(w, "bulk_queue_depth", "Number of requests queued in the bulk processor workers.", "gauge")
+	for i, c := range containers {
+		fmt.Fprintf(w, "%s_bulk_queue_depth{container_id=%q} %d\n", namespace, c.containerID, stats[i].Queued)
+	}
+
+	header(w, "bulk_flush_duration_seconds", "Latency of bulk requests including retries.", "histogram")
+	for _, c := range containers {
+		c.mu.Lock()
+		for i, bound := range flushBuckets {
+			fmt.Fprintf(w, "%s_bulk_flush_duration_seconds_bucket{container_id=%q,le=%q} %d\n", namespace, c.containerID, strconv.FormatFloat(bound, 'g', -1, 64), c.flush.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bulk_flush_duration_seconds_bucket{container_id=%q,le=\"+Inf\"} %d\n", namespace, c.containerID, c.flush.count)
+		fmt.Fprintf(w, "%s_bulk_flush_duration_seconds_sum{container_id=%q} %s\n", namespace, c.containerID, strconv.FormatFloat(c.flush.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_bulk_flush_duration_seconds_count{container_id=%q} %d\n", namespace, c.containerID, c.flush.count)
+		c.mu.Unlock()
+	}
+}
+
+func header(w io.Writer, name, help, tzpe string) {
+	fmt.Fprintf(w, "# HELP %s_%s %s\n", namespace, name, help)
+	fmt.Fprintf(w, "# TYPE %s_%s %s\n", namespace, name, tzpe)
+}
@@ -0,0 +1,45 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_Write(t *testing.T) {
+	c := New("abc")
+	defer Remove("abc")
+
+	c.LineRead()
+	c.LineRead()
+	c.GrokFailure()
+	c.BulkStarted(1)
+	c.BulkFinished(1)
+	c.Item(201)
+	c.Item(429)
+	c.Item(429)
+	c.SetStats(func() BulkStats { return BulkStats{Queued: 3} })
+
+	var nilContainer *Container
+	nilContainer.LineRead()
+
+	var buf bytes.Buffer
+	Write(&buf)
+
+	tests := []string{
+		`docker_log_elasticsearch_lines_read_total{container_id="abc"} 2`,
+		`docker_log_elasticsearch_grok_failures_total{container_id="abc"} 1`,
+		`docker_log_elasticsearch_bulk_requests_total{container_id="abc"} 1`,
+		`docker_log_elasticsearch_bulk_items_indexed_total{container_id="abc"} 1`,
+		`docker_log_elasticsearch_bulk_items_failed_total{container_id="abc",status="429"} 2`,
+		`docker_log_elasticsearch_bulk_queue_depth{container_id="abc"} 3`,
+		`docker_log_elasticsearch_bulk_flush_duration_seconds_bucket{container_id="abc",le="+Inf"} 1`,
+		`docker_log_elasticsearch_bulk_flush_duration_seconds_count{container_id="abc"} 1`,
+		`# TYPE docker_log_elasticsearch_bulk_flush_duration_seconds histogram`,
+	}
+	for _, want := range tests {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("Write() does not contain %q", want)
+		}
+	}
+}
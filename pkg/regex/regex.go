@@ -1,6 +1,7 @@
 package regex
 
 import (
+	"bytes"
 	"fmt"
 	"regexp"
 	"strings"
@@ -9,6 +10,10 @@ import (
 
 var percent = regexp.MustCompile("%.")
 
+// ansi matches CSI sequences, e.g. colors, OSC sequences, e.g. window titles,
+// and the remaining two-character escape sequences
+var ansi = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[@-_])`)
+
 // IsValid check if regex contains a percent sign
 func IsValid(str string) bool {
 	if strings.Contains(str, "%") {
@@ -26,19 +31,33 @@ func ParseDate(now time.Time, regex string) string {
 	// %B     locale's full month name (January)
 	// %d     day of month (01)
 	// %F     full date; same as %Y.%m.%d
+	// %G     year of the ISO week (2018)
+	// %H     hour (00..23)
 	// %j     day of year (001..366)
 	// %m     month (01..12)
+	// %u     day of week (1..7); 1 is Monday
+	// %U     week of year, with Sunday as first day of week (00..53)
+	// %V     ISO week number, with Monday as first day of week (01..53)
 	// %y     last two digits of year (00..99)
 	// %Y     year (2018)
+	// %%     a literal %
+	isoYear, isoWeek := now.ISOWeek()
+	weekday := int(now.Weekday())
 	var regexToStrftime = map[string]string{
 		/*dayZeroPadded         */ `%d`: now.Format("02"),
 		/*monthShort            */ `%b`: now.Format("Jan"),
 		/*monthFull             */ `%B`: now.Format("January"),
 		/*monthFull             */ `%F`: now.Format("2006.01.02"),
+		/*isoYear               */ `%G`: fmt.Sprintf("%d", isoYear),
+		/*hourZeroPadded        */ `%H`: now.Format("15"),
 		/*monthZeroPadded       */ `%m`: now.Format("01"),
+		/*dayOfWeek             */ `%u`: fmt.Sprintf("%d", (weekday+6)%7+1),
+		/*weekOfYearZeroPadded  */ `%U`: fmt.Sprintf("%02d", (now.YearDay()+6-weekday)/7),
+		/*isoWeekZeroPadded     */ `%V`: fmt.Sprintf("%02d", isoWeek),
 		/*yearCentury           */ `%Y`: now.Format("2006"),
 		/*yearZeroPadded        */ `%y`: now.Format("06"),
-		/*dayOfYearZeroPadded   */ `%j`: fmt.Sprintf("%d", now.YearDay()),
+		/*dayOfYearZeroPadded   */ `%j`: fmt.Sprintf("%03d", now.YearDay()),
+		/*percent               */ `%%`: "%",
 	}
 
 	return percent.ReplaceAllStringFunc(regex, func(s string) string {
@@ -46,3 +65,100 @@ func ParseDate(now time.Time, regex string) string {
 	})
 
 }
+
+// Rotation is the period, in which the result of ParseDate may change
+type Rotation int
+
+const (
+	// RotateNever is the rotation of patterns without date sequences
+	RotateNever Rotation = iota
+	// RotateYearly is the rotation of patterns, which contain years
+	RotateYearly
+	// RotateMonthly is the rotation of patterns, which contain months
+	RotateMonthly
+	// RotateWeekly is the rotation of patterns, which contain ISO weeks
+	RotateWeekly
+	// RotateDaily is the rotation of patterns, which contain days
+	RotateDaily
+	// RotateHourly is the rotation of patterns, which contain hours
+	RotateHourly
+)
+
+// rotations maps the format sequences to their unit of time. Weeks
+// of %U start on Sunday, hence they cannot share the ISO week start.
+var rotations = map[string]Rotation{
+	`%Y`: RotateYearly,
+	`%y`: RotateYearly,
+	`%b`: RotateMonthly,
+	`%B`: RotateMonthly,
+	`%m`: RotateMonthly,
+	`%G`: RotateWeekly,
+	`%V`: RotateWeekly,
+	`%U`: RotateDaily,
+	`%d`: RotateDaily,
+	`%F`: RotateDaily,
+	`%j`: RotateDaily,
+	`%u`: RotateDaily,
+	`%H`: RotateHourly,
+}
+
+// calendar are the format sequences of calendar years and months
+var calendar = map[string]bool{`%Y`: true, `%y`: true, `%b`: true, `%B`: true, `%m`: true}
+
+// ParseRotation returns the rotation of the smallest unit of time in the pattern.
+// ISO weeks may span two calendar years or months, hence patterns mixing
+// them, e.g. %Y.%V, rotate daily. The result of ParseDate is the same for
+// all times in a period, so that the start of the period can be a cache key.
+func ParseRotation(regex string) Rotation {
+	rotation := RotateNever
+	mixed := false
+	for _, s := range percent.FindAllString(regex, -1) {
+		if r := rotations[s]; r > rotation {
+			rotation = r
+		}
+		mixed = mixed || calendar[s]
+	}
+	if rotation == RotateWeekly && mixed {
+		return RotateDaily
+	}
+	return rotation
+}
+
+// Start returns the beginning of the period, which contains t
+func (r Rotation) Start(t time.Time) time.Time {
+	switch r {
+	case RotateYearly:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	case RotateMonthly:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	case RotateWeekly:
+		// ISO weeks start on Monday
+		return time.Date(t.Year(), t.Month(), t.Day()-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case RotateDaily:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case RotateHourly:
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+	default:
+		return time.Time{}
+	}
+}
+
+// Wildcard replaces each strftime format sequence with an asterisk,
+// so that all indices created from the same pattern can be searched
+func Wildcard(regex string) string {
+	return percent.ReplaceAllStringFunc(regex, func(s string) string {
+		if s == `%%` {
+			return "%"
+		}
+		return "*"
+	})
+}
+
+// StripANSI removes ANSI escape sequences from a line.
+// The line is returned as is, if it does not contain any escape character.
+func StripANSI(line []byte) []byte {
+	if bytes.IndexByte(line, '\x1b') < 0 {
+		return line
+	}
+	return ansi.ReplaceAll(line, nil)
+}
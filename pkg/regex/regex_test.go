@@ -44,7 +44,7 @@ func Test_ParseDate(t *testing.T) {
 		{name: "yearZeroPadded", args: args{now: time.Now(), pattern: "docker-%Y"}, want: fmt.Sprintf("docker-%s", today.Format("2006"))},
 		{name: "yearZeroPadded", args: args{now: time.Now(), pattern: "docker-%m"}, want: fmt.Sprintf("docker-%s", today.Format("01"))},
 		{name: "yearZeroPadded", args: args{now: time.Now(), pattern: "docker-%d"}, want: fmt.Sprintf("docker-%s", today.Format("02"))},
-		{name: "dayOfYearZeroPadded", args: args{now: time.Now(), pattern: "docker-%j"}, want: fmt.Sprintf("docker-%d", today.YearDay())},
+		{name: "dayOfYearZeroPadded", args: args{now: time.Now(), pattern: "docker-%j"}, want: fmt.Sprintf("docker-%03d", today.YearDay())},
 		{name: "zeroRegex", args: args{now: time.Now(), pattern: "docker"}, want: "docker"},
 	}
 	for _, tt := range tests {
@@ -55,3 +55,101 @@ func Test_ParseDate(t *testing.T) {
 		})
 	}
 }
+
+func Test_ParseDateWeeks(t *testing.T) {
+	tests := []struct {
+		name    string
+		now     time.Time
+		pattern string
+		want    string
+	}{
+		{name: "hour", now: time.Date(2018, 3, 4, 5, 6, 7, 0, time.UTC), pattern: "docker-%Y.%m.%d.%H", want: "docker-2018.03.04.05"},
+		{name: "iso week", now: time.Date(2018, 12, 31, 0, 0, 0, 0, time.UTC), pattern: "docker-%G.%V", want: "docker-2019.01"},
+		{name: "iso week of previous year", now: time.Date(2021, 1, 3, 0, 0, 0, 0, time.UTC), pattern: "docker-%G.%V", want: "docker-2020.53"},
+		{name: "sunday week", now: time.Date(2018, 1, 7, 0, 0, 0, 0, time.UTC), pattern: "docker-%Y.%U", want: "docker-2018.01"},
+		{name: "sunday week before first sunday", now: time.Date(2018, 1, 6, 0, 0, 0, 0, time.UTC), pattern: "docker-%Y.%U", want: "docker-2018.00"},
+		{name: "day of week", now: time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC), pattern: "docker-%u", want: "docker-7"},
+		{name: "day of year", now: time.Date(2018, 1, 9, 0, 0, 0, 0, time.UTC), pattern: "docker-%j", want: "docker-009"},
+		{name: "percent", now: time.Date(2018, 1, 9, 0, 0, 0, 0, time.UTC), pattern: "docker-%%Y-%Y", want: "docker-%Y-2018"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseDate(tt.now, tt.pattern); got != tt.want {
+				t.Errorf("ParseDate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ParseRotation(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    Rotation
+		start   time.Time
+	}{
+		{pattern: "docker", want: RotateNever, start: time.Time{}},
+		{pattern: "docker-%Y", want: RotateYearly, start: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{pattern: "docker-%Y.%m", want: RotateMonthly, start: time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{pattern: "docker-%G.%V", want: RotateWeekly, start: time.Date(2018, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{pattern: "docker-%F", want: RotateDaily, start: time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{pattern: "docker-%Y.%U", want: RotateDaily, start: time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{pattern: "docker-%Y.%V", want: RotateDaily, start: time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{pattern: "docker-%Y.%m-w%V", want: RotateDaily, start: time.Date(2018, 3, 7, 0, 0, 0, 0, time.UTC)},
+		{pattern: "docker-%F.%H", want: RotateHourly, start: time.Date(2018, 3, 7, 8, 0, 0, 0, time.UTC)},
+		{pattern: "docker-%%H", want: RotateNever, start: time.Time{}},
+	}
+	now := time.Date(2018, 3, 7, 8, 9, 10, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			got := ParseRotation(tt.pattern)
+			if got != tt.want {
+				t.Errorf("ParseRotation() = %v, want %v", got, tt.want)
+			}
+			if start := got.Start(now); !start.Equal(tt.start) {
+				t.Errorf("Start() = %v, want %v", start, tt.start)
+			}
+		})
+	}
+}
+
+func Test_Wildcard(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{name: "fullDate", args: "docker-%F", want: "docker-*"},
+		{name: "fullDateCustom", args: "docker.%Y-%m-%d", want: "docker.*-*-*"},
+		{name: "zeroRegex", args: "docker", want: "docker"},
+		{name: "percent", args: "docker-%%-%H", want: "docker-%-*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Wildcard(tt.args); got != tt.want {
+				t.Errorf("Wildcard() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_StripANSI(t *testing.T) {
+	tests := []struct {
+		name string
+		args string
+		want string
+	}{
+		{name: "no escape", args: "plain text", want: "plain text"},
+		{name: "colors", args: "\x1b[32mINFO\x1b[0m started", want: "INFO started"},
+		{name: "bold colors", args: "\x1b[1;31;40mERROR\x1b[m", want: "ERROR"},
+		{name: "cursor", args: "\x1b[2K\x1b[1Gprogress", want: "progress"},
+		{name: "window title", args: "\x1b]0;title\x07prompt", want: "prompt"},
+		{name: "two characters", args: "\x1bMreverse", want: "reverse"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(StripANSI([]byte(tt.args))); got != tt.want {
+				t.Errorf("StripANSI() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
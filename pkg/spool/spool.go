@@ -0,0 +1,277 @@
+package spool
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// DiscardOldest removes the oldest segments, when the spool is full
+	DiscardOldest = "oldest"
+	// DiscardNewest drops the requests, which do not fit in the spool anymore
+	DiscardNewest = "newest"
+
+	segmentExt = ".seg"
+	tmpExt     = ".tmp"
+)
+
+// ErrFull is returned, if the requests were discarded, because the spool is full
+var ErrFull = errors.New("spool is full")
+
+// Spool is a write-ahead log of bulk requests, which could not be delivered.
+// Each batch of requests is stored as one segment file of bulk api lines,
+// which is written to a temporary file and renamed afterwards, so that a
+// crash never leaves a partially written segment behind.
+type Spool struct {
+	mu       sync.Mutex
+	dir      string
+	maxSize  int64
+	discard  string
+	seq      uint64
+	size     int64
+	segments []segment
+	// pending holds the IDs of spooled documents, because
+	// the bulk processor keeps retrying the same requests
+	pending map[string]struct{}
+}
+
+type segment struct {
+	name string
+	size int64
+}
+
+// New opens or creates the spool directory and recovers its segments
+func New(dir string, maxSize int64, discard string) (*Spool, error) {
+	if discard != DiscardOldest && discard != DiscardNewest {
+		return nil, fmt.Errorf("error: unknown discard policy: %s", discard)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{
+		dir:     dir,
+		maxSize: maxSize,
+		discard: discard,
+		pending: make(map[string]struct{}),
+	}
+
+	for _, f := range files {
+		switch filepath.Ext(f.Name()) {
+		case tmpExt:
+			// the segment has not been completely written
+			os.Remove(filepath.Join(dir, f.Name()))
+		case segmentExt:
+			var seq uint64
+			if _, err := fmt.Sscanf(f.Name(), "%020d"+segmentExt, &seq); err != nil {
+				continue
+			}
+			if seq > s.seq {
+				s.seq = seq
+			}
+			s.segments = append(s.segments, segment{name: f.Name(), size: f.Size()})
+			s.size += f.Size()
+		}
+	}
+
+	// segment names are zero padded, so that they are sorted by sequence
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].name < s.segments[j].name })
+
+	return s, nil
+}
+
+// Write stores the lines of a failed bulk request as a new segment.
+// Each request consists of an action line followed by the document line.
+func (s *Spool) Write(lines []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var data []byte
+	var ids []string
+	for i := 0; i+1 < len(lines); i += 2 {
+		id := requestID(lines[i])
+		if _, exists := s.pending[id]; exists {
+			continue
+		}
+		data = append(data, lines[i]...)
+		data = append(data, '\n')
+		data = append(data, lines[i+1]...)
+		data = append(data, '\n')
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	size := int64(len(data))
+	if s.size+size > s.maxSize {
+		if s.discard == DiscardNewest || size > s.maxSize {
+			return ErrFull
+		}
+		for len(s.segments) > 0 && s.size+size > s.maxSize {
+			s.remove(s.segments[0])
+		}
+	}
+
+	s.seq++
+	name := fmt.Sprintf("%020d"+segmentExt, s.seq)
+	if err := s.writeFile(name, data); err != nil {
+		return err
+	}
+
+	s.segments = append(s.segments, segment{name: name, size: size})
+	s.size += size
+	for _, id := range ids {
+		s.pending[id] = struct{}{}
+	}
+
+	return nil
+}
+
+// Replay passes the lines of each segment to fn, the oldest segment first.
+// A segment is removed, once fn succeeds. Replay stops at the first error.
+func (s *Spool) Replay(fn func(lines []string) error) error {
+	for {
+		s.mu.Lock()
+		if len(s.segments) == 0 {
+			s.mu.Unlock()
+			return nil
+		}
+		seg := s.segments[0]
+		s.mu.Unlock()
+
+		lines, err := readLines(filepath.Join(s.dir, seg.name))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if err == nil {
+			if err := fn(lines); err != nil {
+				return err
+			}
+		}
+
+		s.mu.Lock()
+		for i := 0; i+1 < len(lines); i += 2 {
+			delete(s.pending, requestID(lines[i]))
+		}
+		s.remove(seg)
+		s.mu.Unlock()
+	}
+}
+
+// Size returns the size of all segments in bytes
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.size
+}
+
+// Remove deletes the spool directory, unless requests are still spooled
+func (s *Spool) Remove() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) > 0 {
+		return fmt.Errorf("error: spool is not empty: %s", s.dir)
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// remove deletes a segment, the lock must be held
+func (s *Spool) remove(seg segment) {
+	for i := range s.segments {
+		if s.segments[i].name == seg.name {
+			s.segments = append(s.segments[:i], s.segments[i+1:]...)
+			s.size -= seg.size
+			os.Remove(filepath.Join(s.dir, seg.name))
+			return
+		}
+	}
+}
+
+func (s *Spool) writeFile(name string, data []byte) error {
+	tmp := filepath.Join(s.dir, name+tmpExt)
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// persist the rename
+	if d, err := os.Open(s.dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// requestID returns the document ID of a bulk action line, e.g.
+// {"index":{"_id":"1","_index":"docker","_type":"log"}}
+func requestID(action string) string {
+	var header map[string]struct {
+		ID string `json:"_id"`
+	}
+	if err := json.Unmarshal([]byte(action), &header); err != nil {
+		return ""
+	}
+	for _, v := range header {
+		return v.ID
+	}
+	return ""
+}
@@ -0,0 +1,120 @@
+package spool
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func request(id string) []string {
+	return []string{
+		fmt.Sprintf(`{"index":{"_id":"%s","_index":"docker","_type":"log"}}`, id),
+		fmt.Sprintf(`{"message":"%s"}`, id),
+	}
+}
+
+func replay(t *testing.T, s *Spool) []string {
+	var ids []string
+	err := s.Replay(func(lines []string) error {
+		for i := 0; i < len(lines); i += 2 {
+			ids = append(ids, requestID(lines[i]))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Replay() error = %v", err)
+	}
+	return ids
+}
+
+func Test_Spool(t *testing.T) {
+	tests := []struct {
+		name    string
+		discard string
+		batches [][]string
+		want    []string
+	}{
+		{name: "in order", discard: DiscardOldest, batches: [][]string{{"1"}, {"2", "3"}}, want: []string{"1", "2", "3"}},
+		{name: "pending requests", discard: DiscardOldest, batches: [][]string{{"1"}, {"1", "2"}}, want: []string{"1", "2"}},
+		{name: "discard oldest", discard: DiscardOldest, batches: [][]string{{"1", "2"}, {"3", "4"}, {"5", "6"}}, want: []string{"3", "4", "5", "6"}},
+		{name: "discard newest", discard: DiscardNewest, batches: [][]string{{"1", "2"}, {"3", "4"}, {"5", "6"}}, want: []string{"1", "2", "3", "4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "spool")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			// two requests take 182 bytes
+			s, err := New(dir, 400, tt.discard)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			for _, batch := range tt.batches {
+				var lines []string
+				for _, id := range batch {
+					lines = append(lines, request(id)...)
+				}
+				if err := s.Write(lines); err != nil && err != ErrFull {
+					t.Fatalf("Write() error = %v", err)
+				}
+			}
+
+			// recover the segments after a restart
+			ioutil.WriteFile(filepath.Join(dir, "00000000000000000099.seg.tmp"), []byte("partial"), 0600)
+			s, err = New(dir, 400, tt.discard)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			if got := replay(t, s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Replay() = %v, want %v", got, tt.want)
+			}
+			if s.Size() != 0 {
+				t.Errorf("Size() = %v, want 0", s.Size())
+			}
+			if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+				t.Errorf("ReadDir() = %v files, want 0", len(files))
+			}
+		})
+	}
+}
+
+func Test_Spool_Remove(t *testing.T) {
+	root, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	dir := filepath.Join(root, "abc")
+	s, err := New(dir, 400, DiscardOldest)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := s.Write(request("1")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	// the spooled requests are kept
+	if err := s.Remove(); err == nil {
+		t.Errorf("Remove() error = nil, want an error")
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("Stat() error = %v, want the directory", err)
+	}
+
+	replay(t, s)
+	if err := s.Remove(); err != nil {
+		t.Errorf("Remove() error = %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Stat() error = %v, want the directory removed", err)
+	}
+}
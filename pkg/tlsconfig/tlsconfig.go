@@ -0,0 +1,50 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// New creates the tls configuration of the elasticsearch clients.
+// The CA bundle is added to the system's root CAs, the client
+// certificate and key are required for mutual TLS authentication.
+func New(ca, cert, key, serverName string, insecure bool) (*tls.Config, error) {
+
+	config := &tls.Config{
+		InsecureSkipVerify: insecure,
+		ServerName:         serverName,
+	}
+
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("error: reading CA bundle: %v", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("error: no certificates found in CA bundle: %s", ca)
+		}
+		config.RootCAs = pool
+	}
+
+	if (cert == "") != (key == "") {
+		return nil, errors.New("error: client certificate and key must be provided together")
+	}
+
+	if cert != "" {
+		certificate, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("error: loading client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
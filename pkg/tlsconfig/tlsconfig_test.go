@@ -0,0 +1,94 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificate creates a self-signed certificate and its key
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "elasticsearch"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert := filepath.Join(dir, "cert.pem")
+	ioutil.WriteFile(cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+
+	return cert, keyFile
+}
+
+func Test_New(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cert, key := writeCertificate(t, dir)
+
+	type args struct {
+		ca, cert, key string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{name: "no certificates", args: args{}},
+		{name: "ca", args: args{ca: cert}},
+		{name: "client certificate", args: args{ca: cert, cert: cert, key: key}},
+		{name: "missing key", args: args{cert: cert}, wantErr: true},
+		{name: "missing ca", args: args{ca: filepath.Join(dir, "missing.pem")}, wantErr: true},
+		{name: "invalid ca", args: args{ca: key}, wantErr: true},
+		{name: "invalid key", args: args{cert: cert, key: cert}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := New(tt.args.ca, tt.args.cert, tt.args.key, "es.local", false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if config.ServerName != "es.local" {
+				t.Errorf("New() ServerName = %v, want es.local", config.ServerName)
+			}
+			if (tt.args.ca != "") != (config.RootCAs != nil) {
+				t.Errorf("New() RootCAs = %v", config.RootCAs)
+			}
+			if (tt.args.cert != "") != (len(config.Certificates) == 1) {
+				t.Errorf("New() Certificates = %v", len(config.Certificates))
+			}
+		})
+	}
+}